@@ -0,0 +1,91 @@
+package nami
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Rule allows or denies principals to call methods. Patterns use the syntax of path.Match,
+// except '*' and '?' match '/' too, e.g. "Foo.*" matches every method of Foo, "*.Get*" the
+// getters of every service, "spiffe://org/*" every principal under it, "*" anything.
+type Rule struct {
+	Principal string // pattern of the principal, unauthenticated callers have the empty principal
+	Method    string // pattern of "Service.Method"
+	Allow     bool
+}
+
+func (r *Rule) match(info *RequestInfo) bool {
+	ok, _ := matchPattern(r.Principal, info.Principal)
+	if !ok {
+		return false
+	}
+	ok, _ = matchPattern(r.Method, info.ServiceMethod)
+	return ok
+}
+
+// slashReplacer hides '/' from path.Match, so it's matched like any other character
+var slashReplacer = strings.NewReplacer("/", "\x00")
+
+// matchPattern reports whether name matches the pattern of a Rule
+func matchPattern(pattern, name string) (bool, error) {
+	return path.Match(slashReplacer.Replace(pattern), slashReplacer.Replace(name))
+}
+
+// Policy authorizes requests before their method is called, the first matching rule
+// decides. Requests matching no rule are allowed, unless DenyByDefault is set.
+type Policy struct {
+	Rules         []Rule
+	DenyByDefault bool
+	// Audit is called for each denied request with the rule denying it, nil if it's denied by
+	// default. Denied requests are logged as warnings if Audit is nil.
+	Audit func(info *RequestInfo, rule *Rule)
+}
+
+// ErrPermissionDenied is returned for requests denied by the server's Policy
+var ErrPermissionDenied = NewError(CodePermissionDenied, "rpc server: permission denied")
+
+// SetPolicy makes s authorize requests with p, nil removes the policy. It fails if a pattern is malformed.
+func (s *Server) SetPolicy(p *Policy) error {
+	if p != nil {
+		for _, r := range p.Rules {
+			for _, pattern := range []string{r.Principal, r.Method} {
+				if _, err := matchPattern(pattern, ""); err != nil {
+					return fmt.Errorf("rpc server: bad pattern %q in policy: %w", pattern, err)
+				}
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+	return nil
+}
+
+// authorize checks the request of info against the policy of s
+func (s *Server) authorize(info *RequestInfo) error {
+	s.mu.Lock()
+	p := s.policy
+	s.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	var rule *Rule
+	for i := range p.Rules {
+		if p.Rules[i].match(info) {
+			rule = &p.Rules[i]
+			break
+		}
+	}
+	if rule != nil && rule.Allow || rule == nil && !p.DenyByDefault {
+		return nil
+	}
+
+	if p.Audit != nil {
+		p.Audit(info, rule)
+	} else {
+		s.log().Warn("rpc server: permission denied", "remote", info.RemoteAddr, "seq", info.Seq, "method", info.ServiceMethod, "principal", info.Principal)
+	}
+	return ErrPermissionDenied
+}
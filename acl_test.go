@@ -0,0 +1,48 @@
+package nami
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestServerPolicy(t *testing.T) {
+	s := NewServer()
+	var denied []string
+	err := s.SetPolicy(&Policy{
+		Rules: []Rule{
+			{Principal: "admin", Method: "*", Allow: true},
+			{Principal: "*", Method: "Foo.Delete*", Allow: false},
+			{Principal: "svc-*", Method: "Foo.*", Allow: true},
+		},
+		DenyByDefault: true,
+		Audit: func(info *RequestInfo, rule *Rule) {
+			denied = append(denied, info.Principal+" "+info.ServiceMethod)
+		},
+	})
+	_assert(err == nil, "set policy failed: %v", err)
+
+	check := func(principal, serviceMethod string) error {
+		return s.authorize(&RequestInfo{Principal: principal, ServiceMethod: serviceMethod})
+	}
+	_assert(check("admin", "Foo.DeleteAll") == nil, "expect admin to be allowed anything")
+	_assert(check("svc-a", "Foo.Sum") == nil, "expect svc-a to be allowed Foo.Sum")
+	_assert(errors.Is(check("svc-a", "Foo.DeleteAll"), ErrPermissionDenied), "expect svc-a to be denied Foo.DeleteAll")
+	_assert(errors.Is(check("svc-a", "Bar.Info"), ErrPermissionDenied), "expect unmatched request to be denied by default")
+	_assert(errors.Is(check("", "Foo.Sum"), ErrPermissionDenied), "expect unauthenticated caller to be denied")
+	_assert(len(denied) == 3 && denied[0] == "svc-a Foo.DeleteAll", "expect denied calls to be audited, got %v", denied)
+
+	_ = s.SetPolicy(&Policy{Rules: []Rule{{Principal: "*", Method: "Foo.Sum", Allow: false}}})
+	_assert(check("", "Bar.Info") == nil, "expect unmatched request to be allowed without DenyByDefault")
+
+	_ = s.SetPolicy(&Policy{Rules: []Rule{
+		{Principal: "*", Method: "Foo.Delete*", Allow: false},
+		{Principal: "spiffe://org/*", Method: "Foo.*", Allow: true},
+	}, DenyByDefault: true})
+	_assert(errors.Is(check("team/alice", "Foo.DeleteAll"), ErrPermissionDenied), "expect a principal with slashes to be denied by \"*\"")
+	_assert(errors.Is(check("spiffe://org/svc", "Foo.DeleteAll"), ErrPermissionDenied), "expect a principal with slashes to be denied by \"*\"")
+	_assert(check("spiffe://org/svc", "Foo.Sum") == nil, "expect \"spiffe://org/*\" to match spiffe://org/svc")
+	_assert(errors.Is(check("spiffe://other/svc", "Foo.Sum"), ErrPermissionDenied), "expect spiffe://other/svc to be denied by default")
+
+	err = s.SetPolicy(&Policy{Rules: []Rule{{Principal: "[", Method: "*"}}})
+	_assert(err != nil, "expect malformed pattern to be refused")
+}
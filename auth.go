@@ -0,0 +1,173 @@
+package nami
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Challenger sends a challenge to the client being authenticated and returns its response
+type Challenger func(challenge []byte) (response []byte, err error)
+
+// Authenticator authenticates the connections of a Server, it's invoked once the Option
+// is decoded. It returns the principal of the connection, or an error to reject it.
+type Authenticator interface {
+	Authenticate(opt *Option, challenge Challenger) (principal string, err error)
+}
+
+// RequestAuthenticator is implemented by Authenticators which authenticate each request as
+// well, e.g. by a bearer token in its metadata. It returns the principal of the request.
+type RequestAuthenticator interface {
+	AuthenticateRequest(info *RequestInfo) (principal string, err error)
+}
+
+// Credentials authenticates a client to the server's Authenticator
+type Credentials interface {
+	// Credential returns the token or key id sent along with the Option
+	Credential() string
+	// Respond answers a challenge of the server
+	Respond(challenge []byte) ([]byte, error)
+}
+
+// SetAuthenticator makes s authenticate connections with auth, unauthenticated ones are
+// rejected in the handshake with an Unauthenticated error
+func (s *Server) SetAuthenticator(auth Authenticator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authenticator = auth
+}
+
+func (s *Server) getAuthenticator() Authenticator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authenticator
+}
+
+// TokenAuthenticator authenticates connections by a shared secret token
+type TokenAuthenticator struct {
+	tokens map[string]string
+}
+
+// NewTokenAuthenticator returns a TokenAuthenticator accepting the tokens, each one maps to its principal
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	return &TokenAuthenticator{tokens: tokens}
+}
+
+func (a *TokenAuthenticator) Authenticate(opt *Option, _ Challenger) (string, error) {
+	// compare with every token in constant time, so a token can't be guessed by timing
+	principal, ok := "", false
+	for token, p := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(opt.Credential)) == 1 {
+			principal, ok = p, true
+		}
+	}
+	if !ok {
+		return "", NewError(CodeUnauthenticated, "rpc server: invalid token")
+	}
+	return principal, nil
+}
+
+// TokenCredentials authenticates a client to a TokenAuthenticator
+type TokenCredentials string
+
+func (t TokenCredentials) Credential() string {
+	return string(t)
+}
+
+func (t TokenCredentials) Respond([]byte) ([]byte, error) {
+	return nil, errors.New("rpc client: token credentials can't answer a challenge")
+}
+
+// hmacChallengeSize is the size of the random challenge of HMACAuthenticator
+const hmacChallengeSize = 32
+
+// HMACAuthenticator authenticates connections by challenge/response: the client proves it
+// knows the secret of its key id by signing a random challenge with HMAC-SHA256, the secret
+// itself is never sent.
+type HMACAuthenticator struct {
+	secrets map[string][]byte
+}
+
+// NewHMACAuthenticator returns an HMACAuthenticator knowing the secrets by key id, the key id is the principal
+func NewHMACAuthenticator(secrets map[string][]byte) *HMACAuthenticator {
+	return &HMACAuthenticator{secrets: secrets}
+}
+
+func (a *HMACAuthenticator) Authenticate(opt *Option, challenge Challenger) (string, error) {
+	// an unknown key id is challenged too, so it can't be told apart from a wrong secret
+	secret, known := a.secrets[opt.Credential]
+	nonce := make([]byte, hmacChallengeSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", Errorf(CodeInternal, "rpc server: make challenge: %w", err)
+	}
+	resp, err := challenge(nonce)
+	if err != nil {
+		return "", authFailed(fmt.Errorf("challenge failed: %w", err))
+	}
+	if !known {
+		return "", authFailed(fmt.Errorf("unknown key id %q", opt.Credential))
+	}
+	if !hmac.Equal(resp, hmacSign(secret, nonce)) {
+		return "", authFailed(fmt.Errorf("invalid challenge response for key id %q", opt.Credential))
+	}
+	return opt.Credential, nil
+}
+
+// authFailed returns the error of every failed challenge, its cause is only logged by the server
+func authFailed(cause error) *Error {
+	return &Error{Code: CodeUnauthenticated, Message: "rpc server: authentication failed", cause: cause}
+}
+
+// HMACCredentials authenticates a client to an HMACAuthenticator
+type HMACCredentials struct {
+	KeyID  string
+	Secret []byte
+}
+
+func (c *HMACCredentials) Credential() string {
+	return c.KeyID
+}
+
+func (c *HMACCredentials) Respond(challenge []byte) ([]byte, error) {
+	return hmacSign(c.Secret, challenge), nil
+}
+
+func hmacSign(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// AuthorizationKey is the metadata key carrying the bearer token of a request
+const AuthorizationKey = "authorization"
+
+// BearerAuthenticator authenticates each request by the bearer token in its metadata,
+// as "Bearer <token>" under AuthorizationKey. Connections are accepted as they are.
+type BearerAuthenticator struct {
+	verify func(token string) (principal string, err error)
+}
+
+// NewBearerAuthenticator returns a BearerAuthenticator verifying tokens with verify
+func NewBearerAuthenticator(verify func(token string) (principal string, err error)) *BearerAuthenticator {
+	return &BearerAuthenticator{verify: verify}
+}
+
+func (a *BearerAuthenticator) Authenticate(*Option, Challenger) (string, error) {
+	return "", nil
+}
+
+func (a *BearerAuthenticator) AuthenticateRequest(info *RequestInfo) (string, error) {
+	token := strings.TrimPrefix(info.Metadata[AuthorizationKey], "Bearer ")
+	if token == "" || token == info.Metadata[AuthorizationKey] {
+		return "", NewError(CodeUnauthenticated, "rpc server: missing bearer token")
+	}
+	principal, err := a.verify(token)
+	if err != nil {
+		return "", Errorf(CodeUnauthenticated, "rpc server: invalid bearer token: %w", err)
+	}
+	return principal, nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xeasy/nami"
+)
+
+func (f Foo) Principal(ctx context.Context, args Args, reply *string) error {
+	info, _ := nami.RequestInfoFromContext(ctx)
+	*reply = info.Principal
+	return nil
+}
+
+func TestClientTokenAuth(t *testing.T) {
+	server, addr := newServer(t)
+	server.SetAuthenticator(nami.NewTokenAuthenticator(map[string]string{"s3cret": "alice"}))
+
+	cli, err := Dial("tcp", addr, &nami.Option{Credentials: nami.TokenCredentials("s3cret")})
+	_assert(err == nil, "dial with token failed: %v", err)
+	defer cli.Close()
+	var principal string
+	err = cli.Call(context.Background(), "Foo.Principal", &Args{}, &principal)
+	_assert(err == nil && principal == "alice", "expect principal alice, got %q, err %v", principal, err)
+
+	_, err = Dial("tcp", addr, &nami.Option{Credentials: nami.TokenCredentials("wrong")})
+	_assert(errors.Is(err, nami.ErrUnauthenticated), "expect wrong token to be rejected, got %v", err)
+	_, err = Dial("tcp", addr)
+	_assert(errors.Is(err, nami.ErrUnauthenticated), "expect missing token to be rejected, got %v", err)
+}
+
+func TestClientHMACAuth(t *testing.T) {
+	server, addr := newServer(t)
+	server.SetAuthenticator(nami.NewHMACAuthenticator(map[string][]byte{"svc-a": []byte("key-a")}))
+
+	cli, err := Dial("tcp", addr, &nami.Option{Credentials: &nami.HMACCredentials{KeyID: "svc-a", Secret: []byte("key-a")}})
+	_assert(err == nil, "dial with hmac failed: %v", err)
+	defer cli.Close()
+	var principal string
+	err = cli.Call(context.Background(), "Foo.Principal", &Args{}, &principal)
+	_assert(err == nil && principal == "svc-a", "expect principal svc-a, got %q, err %v", principal, err)
+
+	_, err = Dial("tcp", addr, &nami.Option{Credentials: &nami.HMACCredentials{KeyID: "svc-a", Secret: []byte("wrong")}})
+	_assert(errors.Is(err, nami.ErrUnauthenticated), "expect wrong secret to be rejected, got %v", err)
+	_, unknown := Dial("tcp", addr, &nami.Option{Credentials: &nami.HMACCredentials{KeyID: "svc-x", Secret: []byte("key-x")}})
+	_assert(errors.Is(unknown, nami.ErrUnauthenticated) && unknown.Error() == err.Error(), "expect unknown key id to be rejected like a wrong secret, got %v and %v", unknown, err)
+	_assert(!strings.Contains(unknown.Error(), "svc-x"), "expect the key id not to be echoed, got %v", unknown)
+	_, err = Dial("tcp", addr, &nami.Option{Credentials: nami.TokenCredentials("svc-a")})
+	_assert(errors.Is(err, nami.ErrUnauthenticated), "expect credentials unable to answer to be rejected, got %v", err)
+}
+
+func TestClientBearerAuth(t *testing.T) {
+	server, addr := newServer(t)
+	server.SetAuthenticator(nami.NewBearerAuthenticator(func(token string) (string, error) {
+		if token != "t0ken" {
+			return "", errors.New("unknown token")
+		}
+		return "bob", nil
+	}))
+
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+	var principal string
+	err = cli.Call(WithBearerToken(context.Background(), "t0ken"), "Foo.Principal", &Args{}, &principal)
+	_assert(err == nil && principal == "bob", "expect principal bob, got %q, err %v", principal, err)
+	err = cli.Call(WithBearerToken(context.Background(), "bad"), "Foo.Principal", &Args{}, &principal)
+	_assert(errors.Is(err, nami.ErrUnauthenticated), "expect bad token to be rejected, got %v", err)
+	err = cli.Call(context.Background(), "Foo.Principal", &Args{}, &principal)
+	_assert(errors.Is(err, nami.ErrUnauthenticated), "expect missing token to be rejected, got %v", err)
+}
+
+func TestClientPolicy(t *testing.T) {
+	server, addr := newServer(t)
+	server.SetAuthenticator(nami.NewTokenAuthenticator(map[string]string{"a": "alice", "b": "bob"}))
+	_ = server.SetPolicy(&nami.Policy{
+		Rules:         []nami.Rule{{Principal: "alice", Method: "Foo.*", Allow: true}},
+		DenyByDefault: true,
+	})
+
+	alice, err := Dial("tcp", addr, &nami.Option{Credentials: nami.TokenCredentials("a")})
+	_assert(err == nil, "dial failed: %v", err)
+	defer alice.Close()
+	bob, err := Dial("tcp", addr, &nami.Option{Credentials: nami.TokenCredentials("b")})
+	_assert(err == nil, "dial failed: %v", err)
+	defer bob.Close()
+
+	var reply int
+	err = alice.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect alice to be allowed, got %d, err %v", reply, err)
+	err = bob.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, nami.ErrPermissionDenied), "expect bob to be denied, got %v", err)
+	st, err := bob.Stream(context.Background(), "Foo.Count", &Args{Num2: 3}, new(int))
+	_assert(err == nil, "open stream failed: %v", err)
+	err = st.Recv(new(int))
+	_assert(errors.Is(err, nami.ErrPermissionDenied), "expect bob's stream to be denied, got %v", err)
+}
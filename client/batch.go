@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xeasy/nami"
+	"github.com/xeasy/nami/codec"
+)
+
+// Batch collects calls to be sent together by Client.CallBatch. The requests are written
+// at once, and the server handles them concurrently like any other requests.
+type Batch struct {
+	calls []*Call
+}
+
+// Add appends a call to the batch, its reply is filled once the batch is called
+func (b *Batch) Add(serviceMethod string, args any, reply any) *Call {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+	}
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// Len returns the number of calls in the batch
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+// CallBatch sends all calls of batch in a single write and waits for them, it returns
+// the error of each call in the order they were added. Interceptors aren't applied to batched calls.
+func (c *Client) CallBatch(ctx context.Context, batch *Batch) []error {
+	deadline, _ := ctx.Deadline()
+	metadata := MetadataFromContext(ctx)
+	for _, call := range batch.calls {
+		call.deadline = deadline
+		call.metadata = metadata
+	}
+	c.sendBatch(batch.calls)
+
+	errs := make([]error, len(batch.calls))
+	for i, call := range batch.calls {
+		select {
+		case <-call.Done:
+			errs[i] = call.Error
+		case <-ctx.Done():
+			errs[i] = nami.Errorf(nami.ContextCode(ctx.Err()), "rpc client: call failed: %w", ctx.Err())
+			if c.removeCall(call.Seq) != nil {
+				if ctx.Err() != context.DeadlineExceeded {
+					c.sendCancel(call.Seq)
+				}
+				// no reply comes anymore, finish the call on its own
+				call.Error = errs[i]
+				call.done()
+			}
+		}
+	}
+	return errs
+}
+
+// sendBatch registers calls and writes their requests under a single acquisition of the sending lock
+func (c *Client) sendBatch(calls []*Call) {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+
+	headers := make([]*codec.Header, 0, len(calls))
+	bodies := make([]any, 0, len(calls))
+	sent := make([]*Call, 0, len(calls))
+	for _, call := range calls {
+		seq, err := c.registerCall(call)
+		if err != nil {
+			call.Error = err
+			call.done()
+			continue
+		}
+		h := &codec.Header{ServiceMethod: call.ServiceMethod, Seq: seq, Metadata: call.metadata}
+		if !call.deadline.IsZero() {
+			if h.Timeout = time.Until(call.deadline); h.Timeout <= 0 {
+				c.removeCall(seq)
+				call.Error = nami.Errorf(nami.CodeDeadlineExceeded, "rpc client: call failed: %w", context.DeadlineExceeded)
+				call.done()
+				continue
+			}
+		}
+		headers = append(headers, h)
+		bodies = append(bodies, call.Args)
+		sent = append(sent, call)
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	if err := codec.WriteBatch(c.cc, headers, bodies); err != nil {
+		// the calls written before the failing one are answered like any other
+		var be *codec.BatchError
+		if errors.As(err, &be) {
+			sent = sent[be.Index:]
+		}
+		for _, call := range sent {
+			// call may be nil, it means the response has been received and handled
+			if call := c.removeCall(call.Seq); call != nil {
+				call.Error = err
+				call.done()
+			}
+		}
+	}
+}
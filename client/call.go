@@ -1,5 +1,11 @@
 package client
 
+import (
+	"time"
+
+	"github.com/xeasy/nami/codec"
+)
+
 // call represents an active RPC
 type Call struct {
 	Seq           uint64
@@ -7,9 +13,22 @@ type Call struct {
 	Args          any
 	Reply         any
 	Error         error
+	Trailer       map[string]string // trailers attached by server to the response
 	Done          chan *Call
+	deadline      time.Time         // sent to server as the request timeout, zero if there is no deadline
+	metadata      map[string]string // sent to server along with the request
+	kind          codec.Kind
+	stream        *ClientStream   // nil if it's not a stream
+	end           func(err error) // records the end of the call in the client's metrics, nil if it isn't sent
 }
 
 func (call *Call) done() {
+	if call.end != nil {
+		call.end(call.Error)
+		call.end = nil
+	}
+	if call.stream != nil {
+		call.stream.finish(call.Error)
+	}
 	call.Done <- call
 }
@@ -2,7 +2,9 @@ package client
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -32,7 +34,13 @@ type NClient interface {
 	removeCall(seq uint64) *Call
 	terminateCalls(err error)
 	Go(serviceMethod string, args, reply any, done chan *Call) *Call
+	GoContext(ctx context.Context, serviceMethod string, args, reply any, done chan *Call) *Call
 	Call(ctx context.Context, serviceMethod string, args, reply any) error
+	Notify(serviceMethod string, args any) error
+	CallBatch(ctx context.Context, batch *Batch) []error
+	Stream(ctx context.Context, serviceMethod string, args, reply any) (*ClientStream, error)
+	OpenStream(ctx context.Context, serviceMethod string, reply any) (*ClientStream, error)
+	Use(interceptors ...Interceptor)
 	IsAvailable() bool
 }
 
@@ -46,9 +54,12 @@ type Client struct {
 	pending  map[uint64]*Call
 	closing  bool
 	shutdown bool
+	goAway   bool // server is shutting down, no more calls are sent
+
+	interceptors []Interceptor
 }
 
-var ErrShutdown = errors.New("connection is shut down")
+var ErrShutdown = nami.NewError(nami.CodeUnavailable, "connection is shut down")
 
 func NewHTTPClient(conn net.Conn, opt *nami.Option) (NClient, error) {
 	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", nami.DefaultRPCPath))
@@ -67,21 +78,102 @@ func NewHTTPClient(conn net.Conn, opt *nami.Option) (NClient, error) {
 }
 
 func NewClient(conn net.Conn, opt *nami.Option) (NClient, error) {
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		fmt.Println("rpc client: codec error: ", err)
+	var offer []codec.Type
+	for _, typ := range opt.Offer() {
+		if codec.Lookup(typ) != nil {
+			offer = append(offer, typ)
+		}
+	}
+	if len(offer) == 0 {
+		err := fmt.Errorf("invalid codec type %v", opt.Offer())
+		opt.Log().Warn("rpc client: no known codec", "remote", conn.RemoteAddr(), "offer", opt.Offer())
 		return nil, err
 	}
 
-	// send options with server
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		fmt.Println("rpc client: option error:", err)
-		conn.Close()
+	// count the bytes of the connection from the handshake on
+	rwc := opt.ClientMetrics().Conn(conn)
+
+	// send options with server, offering only the codecs we know
+	o := *opt
+	o.CodecTypes = offer
+	o.CodecType = offer[0]
+	if opt.Credentials != nil {
+		o.Credential = opt.Credentials.Credential()
+	}
+	enc := json.NewEncoder(rwc)
+	if err := enc.Encode(&o); err != nil {
+		opt.Log().Warn("rpc client: send option error", "remote", conn.RemoteAddr(), "err", err)
+		rwc.Close()
 		return nil, err
 	}
 
-	return newClientcodec(f(conn), opt), nil
+	if opt.MagicNumber == nami.LegacyMagicNumber {
+		// the server starts serving right after the option, with the codec of CodecType
+		return newClientcodec(codec.Lookup(offer[0])(rwc), opt), nil
+	}
+
+	hs, rest, err := handshake(rwc, enc, opt.Credentials)
+	if err != nil {
+		opt.Log().Warn("rpc client: handshake error", "remote", conn.RemoteAddr(), "err", err)
+		rwc.Close()
+		return nil, err
+	}
+	f := codec.Lookup(hs.CodecType)
+	if f == nil {
+		rwc.Close()
+		return nil, fmt.Errorf("rpc client: server picked unknown codec %s", hs.CodecType)
+	}
+
+	rwc = &bufferedConn{ReadWriteCloser: rwc, r: rest}
+	return newClientcodec(opt.NewCodec(f, rwc, hs.CompressType), opt), nil
+}
+
+// bufferedConn reads from r before falling through to the underlying conn
+type bufferedConn struct {
+	io.ReadWriteCloser
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// handshake reads the server's reply to the Option, answering its challenges with creds.
+// The returned reader goes on with the bytes following the handshake, the decoder may
+// have read ahead into the first replies.
+func handshake(conn io.Reader, enc *json.Encoder, creds nami.Credentials) (*nami.Handshake, io.Reader, error) {
+	dec := json.NewDecoder(conn)
+	for {
+		var hs nami.Handshake
+		if err := dec.Decode(&hs); err != nil {
+			return nil, nil, err
+		}
+		if hs.Error != "" {
+			code := nami.Code(hs.Code)
+			if code == nami.CodeOK {
+				code = nami.CodeUnknown
+			}
+			return nil, nil, nami.NewError(code, hs.Error)
+		}
+		if len(hs.Challenge) == 0 {
+			// hand the bytes read ahead (minus the newline terminating the handshake) on
+			buffered, _ := io.ReadAll(dec.Buffered())
+			buffered = bytes.TrimPrefix(buffered, []byte("\n"))
+			return &hs, io.MultiReader(bytes.NewReader(buffered), conn), nil
+		}
+
+		var resp nami.AuthResponse
+		if creds == nil {
+			resp.Error = "rpc client: no credentials to answer the challenge"
+		} else if b, err := creds.Respond(hs.Challenge); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Response = b
+		}
+		if err := enc.Encode(&resp); err != nil {
+			return nil, nil, err
+		}
+	}
 }
 
 func newClientcodec(cc codec.Codec, opt *nami.Option) NClient {
@@ -107,7 +199,9 @@ func parseOptions(opts ...*nami.Option) (*nami.Option, error) {
 		return nil, errors.New("too many options, more than 1")
 	}
 	opt := opts[0]
-	opt.MagicNumber = nami.DefaultOption.MagicNumber
+	if opt.MagicNumber != nami.LegacyMagicNumber {
+		opt.MagicNumber = nami.DefaultOption.MagicNumber
+	}
 	if opt.CodecType == "" {
 		opt.CodecType = nami.DefaultOption.CodecType
 	}
@@ -115,7 +209,8 @@ func parseOptions(opts ...*nami.Option) (*nami.Option, error) {
 	return opt, nil
 }
 
-// rpcAddr eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
+// rpcAddr eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, tls@10.0.0.1:9443, unix@/tmp/geerpc.sock
+// tls uses the TLSConfig of the option, the system roots if it has none
 func XDial(rpcAddr string, opts ...*nami.Option) (NClient, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
@@ -125,6 +220,15 @@ func XDial(rpcAddr string, opts ...*nami.Option) (NClient, error) {
 	switch protool {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "tls":
+		var config *tls.Config
+		if len(opts) > 0 && opts[0] != nil {
+			config = opts[0].TLSConfig
+		}
+		if config == nil {
+			config = &tls.Config{}
+		}
+		return DialTLS("tcp", addr, config, opts...)
 	default:
 		return Dial(protool, addr, opts...)
 	}
@@ -138,6 +242,17 @@ func DialHTTP(nw, addr string, opts ...*nami.Option) (NClient, error) {
 	return dialTimeout(NewHTTPClient, nw, addr, opts...)
 }
 
+// DialTLS connects to a server over TLS with config, which overrides the TLSConfig of the option
+func DialTLS(nw, addr string, config *tls.Config, opts ...*nami.Option) (NClient, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	o := *opt
+	o.TLSConfig = config
+	return dialTimeout(NewClient, nw, addr, &o)
+}
+
 func dialTimeout(ncFunc newClientFunc, nw, addr string, opts ...*nami.Option) (NClient, error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
@@ -148,8 +263,19 @@ func dialTimeout(ncFunc newClientFunc, nw, addr string, opts ...*nami.Option) (N
 		return nil, err
 	}
 
+	if opt.TLSConfig != nil {
+		conn = tls.Client(conn, tlsConfig(opt.TLSConfig, addr))
+	}
+
 	ch := make(chan clientResult)
 	go func() {
+		if tc, ok := conn.(*tls.Conn); ok {
+			if err := tc.Handshake(); err != nil {
+				conn.Close()
+				ch <- clientResult{nil, fmt.Errorf("rpc client: tls handshake error: %w", err)}
+				return
+			}
+		}
 		client, err := ncFunc(conn, opt)
 		if client == nil {
 			conn.Close()
@@ -170,6 +296,20 @@ func dialTimeout(ncFunc newClientFunc, nw, addr string, opts ...*nami.Option) (N
 	}
 }
 
+// tlsConfig returns config with ServerName set to the host of addr if it's empty
+func tlsConfig(config *tls.Config, addr string) *tls.Config {
+	if config.ServerName != "" || config.InsecureSkipVerify {
+		return config
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
 func (client *Client) Close() error {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -183,24 +323,59 @@ func (client *Client) Close() error {
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.goAway
 }
 
 func (c *Client) receive() {
 	var err error
 	for err == nil {
 		var h codec.Header
-		if err := c.cc.ReadHeader(&h); err != nil {
+		if err = c.cc.ReadHeader(&h); err != nil {
 			break
 		}
 
+		if h.Kind == codec.KindGoAway {
+			c.mu.Lock()
+			c.goAway = true
+			c.mu.Unlock()
+			err = c.cc.ReadBody(nil)
+			continue
+		}
+
+		if h.Kind == codec.KindStreamAck {
+			var credits int
+			if err = c.cc.ReadBody(&credits); err != nil {
+				continue
+			}
+			if call := c.pendingCall(h.Seq); call != nil && call.stream != nil {
+				call.stream.grant(credits)
+			}
+			continue
+		}
+
+		if h.Kind == codec.KindStreamMsg {
+			call := c.pendingCall(h.Seq)
+			if call == nil || call.stream == nil {
+				err = c.cc.ReadBody(nil)
+				continue
+			}
+			err = call.stream.receive(c.cc)
+			continue
+		}
+
 		call := c.removeCall(h.Seq)
+		if call != nil {
+			call.Trailer = h.Metadata
+		}
 		switch {
 		case call == nil:
 			// it means write failed and call was removed
 			err = c.cc.ReadBody(nil)
-		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+		case h.Error != "" || h.Code != uint32(nami.CodeOK):
+			call.Error = nami.ErrorFromHeader(&h)
+			err = c.cc.ReadBody(nil)
+			call.done()
+		case h.Kind == codec.KindStreamEnd:
 			err = c.cc.ReadBody(nil)
 			call.done()
 		default:
@@ -219,6 +394,8 @@ func (c *Client) registerCall(call *Call) (uint64, error) {
 	if !c.IsAvailable() {
 		return 0, ErrShutdown
 	}
+	// a call refused by a closed client isn't recorded, it never reaches the server
+	call.end = c.opt.ClientMetrics().Begin(call.ServiceMethod)
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -229,6 +406,13 @@ func (c *Client) registerCall(call *Call) (uint64, error) {
 	return call.Seq, nil
 }
 
+// pendingCall returns the pending call seq without removing it
+func (c *Client) pendingCall(seq uint64) *Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending[seq]
+}
+
 func (c *Client) removeCall(seq uint64) *Call {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -243,7 +427,8 @@ func (c *Client) terminateCalls(err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.shutdown = true
-	for _, call := range c.pending {
+	for seq, call := range c.pending {
+		delete(c.pending, seq)
 		call.Error = err
 		call.done()
 	}
@@ -266,6 +451,17 @@ func (c *Client) send(call *Call) {
 	c.header.ServiceMethod = call.ServiceMethod
 	c.header.Seq = call.Seq
 	c.header.Error = ""
+	c.header.Kind = call.kind
+	c.header.Metadata = call.metadata
+	c.header.Timeout = 0
+	if !call.deadline.IsZero() {
+		if c.header.Timeout = time.Until(call.deadline); c.header.Timeout <= 0 {
+			c.removeCall(seq)
+			call.Error = nami.Errorf(nami.CodeDeadlineExceeded, "rpc client: call failed: %w", context.DeadlineExceeded)
+			call.done()
+			return
+		}
+	}
 
 	// ecode and send the request
 	if err := c.cc.Write(&c.header, call.Args); err != nil {
@@ -279,7 +475,102 @@ func (c *Client) send(call *Call) {
 	}
 }
 
+// Use appends interceptors to the chain wrapping Go and Call, the first one is the outermost
+func (c *Client) Use(interceptors ...Interceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interceptors = append(c.interceptors[:len(c.interceptors):len(c.interceptors)], interceptors...)
+}
+
+func (c *Client) invoker() Invoker {
+	c.mu.Lock()
+	interceptors := c.interceptors
+	c.mu.Unlock()
+	return ChainInterceptors(interceptors, c.invoke)
+}
+
+// invoke sends call and waits for the reply, it may be invoked many times on the same call by interceptors
+func (c *Client) invoke(ctx context.Context, call *Call) error {
+	attempt := &Call{
+		ServiceMethod: call.ServiceMethod,
+		Args:          call.Args,
+		Reply:         call.Reply,
+		Done:          make(chan *Call, 1),
+	}
+	attempt.deadline, _ = ctx.Deadline()
+	attempt.metadata = MetadataFromContext(ctx)
+	c.send(attempt)
+	call.Seq = attempt.Seq
+	select {
+	case <-ctx.Done():
+		// the server gives up on its own when the deadline is exceeded, since it was sent along with the request
+		err := nami.Errorf(nami.ContextCode(ctx.Err()), "rpc client: call failed: %w", ctx.Err())
+		if c.removeCall(attempt.Seq) != nil {
+			if ctx.Err() != context.DeadlineExceeded {
+				c.sendCancel(attempt.Seq)
+			}
+			// no reply comes anymore, finish the attempt on its own
+			attempt.Error = err
+			attempt.done()
+		}
+		return err
+	case <-attempt.Done:
+		call.Trailer = attempt.Trailer
+		return attempt.Error
+	}
+}
+
+// sendCancel tells server to stop handling the request seq
+func (c *Client) sendCancel(seq uint64) {
+	if err := c.sendControl(codec.KindCancel, seq, struct{}{}); err != nil {
+		c.opt.Log().Warn("rpc client: send cancel error", "seq", seq, "err", err)
+	}
+}
+
+// sendControl sends a control frame about the request seq
+func (c *Client) sendControl(kind codec.Kind, seq uint64, body any) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.cc.Write(&codec.Header{Kind: kind, Seq: seq}, body)
+}
+
+// Notify sends a one-way call, the server runs the method without replying and no call
+// is kept pending. It returns once the request is written, errors of the method are
+// only logged by server.
+func (c *Client) Notify(serviceMethod string, args any) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+
+	// a seq is still taken, so the server can tell the request apart from the others
+	if !c.IsAvailable() {
+		return ErrShutdown
+	}
+	c.mu.Lock()
+	seq := c.seq
+	c.seq++
+	c.mu.Unlock()
+
+	c.header.ServiceMethod = serviceMethod
+	c.header.Seq = seq
+	c.header.Error = ""
+	c.header.Kind = codec.KindNotify
+	c.header.Metadata = nil
+	c.header.Timeout = 0
+	end := c.opt.ClientMetrics().Begin(serviceMethod)
+	err := c.cc.Write(&c.header, args)
+	end(err)
+	return err
+}
+
+// Go invokes the function asynchronously, it can't be cancelled, see GoContext
 func (c *Client) Go(serviceMethod string, args any, reply any, done chan *Call) *Call {
+	return c.GoContext(context.Background(), serviceMethod, args, reply, done)
+}
+
+// GoContext invokes the function asynchronously, ctx cancels the call and carries its
+// deadline and metadata as in Call. When interceptors are installed or ctx can be done, the
+// call is sent by another goroutine and its Seq is left zero, since it's unknown on return.
+func (c *Client) GoContext(ctx context.Context, serviceMethod string, args any, reply any, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 1)
 	} else if cap(done) == 0 {
@@ -292,17 +583,34 @@ func (c *Client) Go(serviceMethod string, args any, reply any, done chan *Call)
 		Reply:         reply,
 		Done:          done,
 	}
-	c.send(call)
+
+	c.mu.Lock()
+	intercepted := len(c.interceptors) > 0
+	c.mu.Unlock()
+	if !intercepted && ctx.Done() == nil {
+		call.metadata = MetadataFromContext(ctx)
+		c.send(call)
+		return call
+	}
+
+	invoker := c.invoker()
+	go func() {
+		// the caller owns call until it's done, the chain works on its own copy
+		inner := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply}
+		err := invoker(ctx, inner)
+		call.Trailer = inner.Trailer
+		call.Error = err
+		call.done()
+	}()
 	return call
 }
 
 func (c *Client) Call(ctx context.Context, serviceMethod string, args any, reply any) error {
-	call := c.Go(serviceMethod, args, reply, make(chan *Call, 1))
-	select {
-	case <-ctx.Done():
-		c.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
-	case call := <-call.Done:
-		return call.Error
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
 	}
+	call.Error = c.invoker()(ctx, call)
+	return call.Error
 }
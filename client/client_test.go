@@ -0,0 +1,1063 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xeasy/nami"
+	"github.com/xeasy/nami/codec"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Fail(args Args, reply *int) error {
+	return errors.New("foo: always fail")
+}
+
+func (f Foo) Echo(args []int, reply *[]int) error {
+	*reply = args
+	return nil
+}
+
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(args.Num1))
+	*reply = args.Num1
+	return nil
+}
+
+// meeting is where concurrent Foo.Meet calls wait for each other
+var meeting = make(chan struct{})
+
+// Meet waits for another call of Meet, it fails if calls are handled one by one
+func (f Foo) Meet(args Args, reply *int) error {
+	select {
+	case meeting <- struct{}{}:
+	case <-meeting:
+	case <-time.After(time.Second):
+		return errors.New("no concurrent call to meet")
+	}
+	*reply = args.Num1
+	return nil
+}
+
+// waitCancelled receives the error of Foo.Wait's context once it's done
+var waitCancelled = make(chan error, 1)
+
+func (f Foo) Wait(ctx context.Context, args Args, reply *int) error {
+	select {
+	case <-ctx.Done():
+		waitCancelled <- ctx.Err()
+		return ctx.Err()
+	case <-time.After(time.Millisecond * time.Duration(args.Num1)):
+		return nil
+	}
+}
+
+func (f Foo) Meta(ctx context.Context, args Args, reply *string) error {
+	*reply = nami.MetadataFromContext(ctx)["tenant"]
+	nami.SetTrailer(ctx, "handled-by", "foo")
+	return nil
+}
+
+func (f Foo) Validate(args Args, reply *int) error {
+	if args.Num1 < 0 {
+		return nami.Errorf(nami.CodeInvalidArgument, "Num1 is %d%%", args.Num1).WithDetail("field", "Num1")
+	}
+	return nil
+}
+
+func (f Foo) Count(args Args, stream nami.ServerStream) error {
+	for i := args.Num1; i < args.Num2; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	if args.Num2 < args.Num1 {
+		return nami.NewError(nami.CodeInvalidArgument, "Num2 < Num1")
+	}
+	nami.SetTrailer(stream.Context(), "count", fmt.Sprint(args.Num2-args.Num1))
+	return nil
+}
+
+// Squares streams the squares below Num1, and a string once Num2 is set
+func (f Foo) Squares(args Args, stream nami.TypedServerStream[int]) error {
+	for i := 0; i < args.Num1; i++ {
+		if err := stream.Send(i * i); err != nil {
+			return err
+		}
+	}
+	if args.Num2 != 0 {
+		return stream.ServerStream.Send("not an int")
+	}
+	return nil
+}
+
+// countSent receives how many messages Foo.Forever sent before it's stopped
+var countSent = make(chan int, 1)
+
+func (f Foo) Forever(ctx context.Context, args Args, stream nami.ServerStream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(i); err != nil {
+			countSent <- i
+			return err
+		}
+	}
+}
+
+func (f Foo) Total(in nami.ReceiveStream, reply *int) error {
+	for {
+		var n int
+		if err := in.Recv(&n); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		*reply += n
+	}
+}
+
+func (f Foo) Double(ctx context.Context, in nami.ReceiveStream, out nami.ServerStream) error {
+	for {
+		var n int
+		if err := in.Recv(&n); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := out.Send(n * 2); err != nil {
+			return err
+		}
+	}
+}
+
+// idleStarted is notified once Foo.Idle is called
+var idleStarted = make(chan struct{}, 1)
+
+// Idle never receives the messages sent to it
+func (f Foo) Idle(in nami.ReceiveStream, reply *int) error {
+	idleStarted <- struct{}{}
+	<-in.Context().Done()
+	return in.Context().Err()
+}
+
+// recorded receives the args of Foo.Record
+var recorded = make(chan int, 1)
+
+func (f Foo) Record(args Args, reply *int) error {
+	recorded <- args.Num1
+	return nil
+}
+
+func _assert(condition bool, msg string, v ...any) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func startServer(t *testing.T) string {
+	_, addr := newServer(t)
+	return addr
+}
+
+func newServer(t *testing.T) (*nami.Server, string) {
+	var foo Foo
+	server := nami.NewServer()
+	_ = server.Regiest(&foo)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen: ", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+func TestClientCodecs(t *testing.T) {
+	addr := startServer(t)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.FrameType, codec.MsgpackType} {
+		t.Run(string(typ), func(t *testing.T) {
+			cli, err := Dial("tcp", addr, &nami.Option{CodecType: typ})
+			_assert(err == nil, "dial with %s failed: %v", typ, err)
+			defer cli.Close()
+
+			var reply int
+			err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "Foo.Sum with %s: reply %d, err %v", typ, reply, err)
+
+			err = cli.Call(context.Background(), "Foo.Fail", &Args{}, &reply)
+			_assert(err != nil && err.Error() == "foo: always fail", "Foo.Fail with %s: unexpected err %v", typ, err)
+
+			// the body of an unknown method is discarded and the connection stays usable
+			err = cli.Call(context.Background(), "Foo.Unknown", &Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err != nil, "Foo.Unknown with %s: expect error", typ)
+			err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 3, Num2: 4}, &reply)
+			_assert(err == nil && reply == 7, "Foo.Sum after unknown method with %s: reply %d, err %v", typ, reply, err)
+		})
+	}
+}
+
+func TestClientNegotiate(t *testing.T) {
+	addr := startServer(t)
+
+	cli, err := Dial("tcp", addr, &nami.Option{CodecTypes: []codec.Type{"application/unknown", codec.JsonType, codec.GobType}})
+	_assert(err == nil, "dial with codec offer failed: %v", err)
+	_, ok := cli.(*Client).cc.(*codec.JsonCodec)
+	_assert(ok, "expect first supported codec %s to be picked", codec.JsonType)
+	cli.Close()
+
+	_, err = Dial("tcp", addr, &nami.Option{CodecType: "application/unknown"})
+	_assert(err != nil, "expect dial error for unsupported codec")
+
+	// a codec the client knows but the server doesn't is rejected with a handshake reply
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer conn.Close()
+	_ = json.NewEncoder(conn).Encode(&nami.Option{MagicNumber: nami.MagicNumber, CodecType: "application/unknown"})
+	var hs nami.Handshake
+	err = json.NewDecoder(conn).Decode(&hs)
+	_assert(err == nil && hs.Error != "" && hs.CodecType == "", "expect handshake error, got %+v, err %v", hs, err)
+}
+
+func TestClientLegacy(t *testing.T) {
+	addr := startServer(t)
+
+	// a client predating the handshake is served without one
+	cli, err := Dial("tcp", addr, &nami.Option{MagicNumber: nami.LegacyMagicNumber, CodecType: codec.JsonType, CompressType: codec.GzipCompress})
+	_assert(err == nil, "legacy dial failed: %v", err)
+	defer cli.Close()
+	var reply int
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "legacy call failed: %d, %v", reply, err)
+
+	// the server replies nothing to a legacy client, the first bytes read are the response
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer conn.Close()
+	_ = json.NewEncoder(conn).Encode(&nami.Option{MagicNumber: nami.LegacyMagicNumber, CodecType: codec.JsonType})
+	cc := codec.NewJsonCodec(conn)
+	_ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, &Args{Num1: 2, Num2: 3})
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 1 && h.Error == "", "expect a response header, got %+v", h)
+	_assert(cc.ReadBody(&reply) == nil && reply == 5, "expect 5, got %d", reply)
+}
+
+// paddedConn starts both directions of a conn with a space, like a binary codec whose
+// first frame begins with a whitespace byte
+type paddedConn struct {
+	io.ReadWriteCloser
+	read, written bool
+}
+
+func (c *paddedConn) Read(p []byte) (int, error) {
+	if !c.read {
+		var pad [1]byte
+		if _, err := io.ReadFull(c.ReadWriteCloser, pad[:]); err != nil {
+			return 0, err
+		}
+		if pad[0] != ' ' {
+			return 0, fmt.Errorf("expect a leading space, got %q", pad[0])
+		}
+		c.read = true
+	}
+	return c.ReadWriteCloser.Read(p)
+}
+
+func (c *paddedConn) Write(p []byte) (int, error) {
+	if !c.written {
+		if _, err := c.ReadWriteCloser.Write([]byte{' '}); err != nil {
+			return 0, err
+		}
+		c.written = true
+	}
+	return c.ReadWriteCloser.Write(p)
+}
+
+func TestClientLeadingWhitespace(t *testing.T) {
+	const padded codec.Type = "application/x-padded-json"
+	codec.Register(padded, func(conn io.ReadWriteCloser) codec.Codec {
+		return codec.NewJsonCodec(&paddedConn{ReadWriteCloser: conn})
+	})
+	addr := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer conn.Close()
+
+	// the option and the first request go out in one write, so the server reads them together
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(&nami.Option{MagicNumber: nami.LegacyMagicNumber, CodecType: padded})
+	bc := codec.NewJsonCodec(&paddedConn{ReadWriteCloser: nopCloser{&buf}})
+	_ = bc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, &Args{Num1: 2, Num2: 3})
+	_, err = conn.Write(buf.Bytes())
+	_assert(err == nil, "write failed: %v", err)
+
+	cc := codec.NewJsonCodec(&paddedConn{ReadWriteCloser: conn})
+	var h codec.Header
+	var reply int
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 1 && h.Error == "", "expect a response header, got %+v", h)
+	_assert(cc.ReadBody(&reply) == nil && reply == 5, "expect 5, got %d", reply)
+}
+
+type nopCloser struct{ io.ReadWriter }
+
+func (nopCloser) Close() error { return nil }
+
+func TestClientHandshakeReadAhead(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go func() {
+		var opt nami.Option
+		_ = json.NewDecoder(c2).Decode(&opt)
+		// the handshake and the first frame go out in one write, the client reads them together
+		var buf bytes.Buffer
+		_ = json.NewEncoder(&buf).Encode(&nami.Handshake{CodecType: codec.FrameType, CompressType: codec.NoCompress})
+		_ = codec.NewFrameCodec(nopCloser{&buf}).Write(&codec.Header{Kind: codec.KindGoAway}, struct{}{})
+		_, _ = c2.Write(buf.Bytes())
+		_, _ = io.Copy(io.Discard, c2)
+	}()
+
+	cli, err := NewClient(c1, &nami.Option{MagicNumber: nami.MagicNumber, CodecType: codec.FrameType})
+	_assert(err == nil, "new client failed: %v", err)
+	defer cli.Close()
+	deadline := time.Now().Add(time.Second)
+	for cli.IsAvailable() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(!cli.IsAvailable(), "expect the GoAway sent along with the handshake to be read")
+}
+
+func TestClientCompress(t *testing.T) {
+	addr := startServer(t)
+	args := make([]int, 4096)
+	for i := range args {
+		args[i] = i % 7
+	}
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.FrameType, codec.MsgpackType} {
+		for _, compress := range []codec.CompressType{codec.GzipCompress, codec.FlateCompress} {
+			cli, err := Dial("tcp", addr, &nami.Option{CodecType: typ, CompressType: compress})
+			_assert(err == nil, "dial with %s/%s failed: %v", typ, compress, err)
+
+			var reply []int
+			err = cli.Call(context.Background(), "Foo.Echo", args, &reply)
+			_assert(err == nil && reflect.DeepEqual(args, reply), "Foo.Echo with %s/%s: err %v", typ, compress, err)
+
+			var sum int
+			err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum)
+			_assert(err == nil && sum == 3, "Foo.Sum with %s/%s: reply %d, err %v", typ, compress, sum, err)
+			cli.Close()
+		}
+	}
+}
+
+func TestClientDecodeErrorInSync(t *testing.T) {
+	addr := startServer(t)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.FrameType, codec.MsgpackType} {
+		cli, err := Dial("tcp", addr, &nami.Option{CodecType: typ})
+		_assert(err == nil, "dial with %s failed: %v", typ, err)
+
+		var reply []int
+		err = cli.Call(context.Background(), "Foo.Echo", []any{1, "x", 3}, &reply)
+		_assert(err != nil, "expect Foo.Echo of a mistyped element to fail with %s", typ)
+
+		var sum int
+		err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "expect the next call with %s to succeed, got %d, err %v", typ, sum, err)
+		cli.Close()
+	}
+}
+
+func TestClientEncodeErrorInSync(t *testing.T) {
+	addr := startServer(t)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.FrameType, codec.MsgpackType} {
+		cli, err := Dial("tcp", addr, &nami.Option{CodecType: typ})
+		_assert(err == nil, "dial with %s failed: %v", typ, err)
+
+		var sum int
+		err = cli.Call(context.Background(), "Foo.Sum", make(chan int), &sum)
+		_assert(err != nil, "expect a call of unencodable args to fail with %s", typ)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err = cli.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum)
+		cancel()
+		_assert(err == nil && sum == 3, "expect the next call with %s to succeed, got %d, err %v", typ, sum, err)
+		cli.Close()
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	server, addr := newServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	call := cli.Go("Foo.Sleep", &Args{Num1: 200}, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	_assert(err == nil, "expect graceful shutdown, got %v", err)
+
+	// the in-flight call was drained before the connection was closed
+	<-call.Done
+	_assert(call.Error == nil && *call.Reply.(*int) == 200, "in-flight call failed: %v", call.Error)
+	_assert(!cli.IsAvailable(), "client should be unavailable after server going away")
+
+	_, err = Dial("tcp", addr)
+	_assert(err != nil, "dial should fail after shutdown")
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	server, addr := newServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	call := cli.Go("Foo.Sleep", &Args{Num1: 1000}, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+
+	<-call.Done
+	_assert(call.Error != nil, "in-flight call should fail on forced close")
+}
+
+func TestServerShutdownStalledPeer(t *testing.T) {
+	server, _ := newServer(t)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go server.ServeConn(c1)
+
+	// a legacy client gets no handshake, it never reads anything
+	_ = json.NewEncoder(c2).Encode(&nami.Option{MagicNumber: nami.LegacyMagicNumber, CodecType: codec.JsonType})
+	cc := codec.NewJsonCodec(c2)
+	go func() { _ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1}, &Args{Num1: 1000}) }()
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(ctx) }()
+	select {
+	case err := <-done:
+		_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown hangs on a peer not reading")
+	}
+}
+
+func TestServerShutdownRejects(t *testing.T) {
+	server, addr := newServer(t)
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer conn.Close()
+	_ = json.NewEncoder(conn).Encode(&nami.Option{MagicNumber: nami.LegacyMagicNumber, CodecType: codec.JsonType})
+	cc := codec.NewJsonCodec(conn)
+	_ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1}, &Args{Num1: 200})
+	time.Sleep(time.Millisecond * 50)
+
+	go server.Shutdown(context.Background())
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && h.Kind == codec.KindGoAway, "expect a GoAway, got %+v", h)
+	_ = cc.ReadBody(nil)
+
+	// a request sent before the GoAway is seen is refused, not dropped
+	_ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 2}, &Args{Num1: 1, Num2: 2})
+	h = codec.Header{}
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 2, "expect the reply of seq 2, got %+v", h)
+	_assert(errors.Is(nami.ErrorFromHeader(&h), nami.ErrServerClosed), "expect ErrServerClosed, got %q", h.Error)
+	_ = cc.ReadBody(nil)
+
+	h = codec.Header{}
+	var reply int
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 1 && cc.ReadBody(&reply) == nil && reply == 200, "expect the in-flight call to be served, got %+v", h)
+}
+
+func TestClientInterceptors(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var order []string
+	var attempts int
+	cli.Use(func(ctx context.Context, call *Call, invoker Invoker) error {
+		order = append(order, "outer:"+call.ServiceMethod)
+		err := invoker(ctx, call)
+		if err != nil {
+			return fmt.Errorf("%s: %w", call.ServiceMethod, err)
+		}
+		return nil
+	}, func(ctx context.Context, call *Call, invoker Invoker) error {
+		order = append(order, "inner")
+		// retry once
+		attempts++
+		if err := invoker(ctx, call); err == nil {
+			return nil
+		}
+		attempts++
+		return invoker(ctx, call)
+	})
+
+	var reply int
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "Foo.Sum through interceptors: reply %d, err %v", reply, err)
+	_assert(reflect.DeepEqual(order, []string{"outer:Foo.Sum", "inner"}), "wrong interceptor order %v", order)
+
+	attempts = 0
+	err = cli.Call(context.Background(), "Foo.Fail", &Args{}, &reply)
+	_assert(err != nil && err.Error() == "Foo.Fail: foo: always fail", "expect rewritten error, got %v", err)
+	_assert(attempts == 2, "expect Foo.Fail retried once, got %d attempts", attempts)
+
+	call := <-cli.Go("Foo.Sum", &Args{Num1: 2, Num2: 2}, &reply, nil).Done
+	_assert(call.Error == nil && reply == 4, "Foo.Sum by Go through interceptors: reply %d, err %v", reply, call.Error)
+}
+
+func TestServerHandleTimeout(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr, &nami.Option{HandleTimeout: time.Millisecond * 50})
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var reply int
+	err = cli.Call(context.Background(), "Foo.Wait", &Args{Num1: 1000}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect handle timeout, got %v", err)
+	select {
+	case err = <-waitCancelled:
+		_assert(err == context.DeadlineExceeded, "expect method context deadline exceeded, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("method context wasn't cancelled on handle timeout")
+	}
+
+	err = cli.Call(context.Background(), "Foo.Wait", &Args{Num1: 1}, &reply)
+	_assert(err == nil, "Foo.Wait within timeout failed: %v", err)
+}
+
+func TestClientDeadlinePropagation(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	var reply int
+	err = cli.Call(ctx, "Foo.Wait", &Args{Num1: 1000}, &reply)
+	_assert(err != nil, "expect Foo.Wait to exceed the deadline")
+	select {
+	case err = <-waitCancelled:
+		_assert(err == context.DeadlineExceeded, "expect method context deadline exceeded, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("client deadline wasn't propagated to the server")
+	}
+
+	err = cli.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect expired deadline to fail the call")
+}
+
+func TestClientCancel(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	var reply int
+	err = cli.Call(ctx, "Foo.Wait", &Args{Num1: 5000}, &reply)
+	_assert(err != nil, "expect cancelled Foo.Wait to fail")
+	select {
+	case err = <-waitCancelled:
+		_assert(err == context.Canceled, "expect method context cancelled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("server kept handling the cancelled request")
+	}
+
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "Foo.Sum after cancel: reply %d, err %v", reply, err)
+}
+
+func TestClientGoContext(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+	cli.Use(func(ctx context.Context, call *Call, invoker Invoker) error {
+		return invoker(ctx, call)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	call := cli.GoContext(ctx, "Foo.Wait", &Args{Num1: 5000}, new(int), nil)
+	_assert(call.Seq == 0, "expect Seq to be left zero")
+	select {
+	case <-call.Done:
+		_assert(errors.Is(call.Error, nami.ErrCanceled), "expect the call to be cancelled, got %v", call.Error)
+	case <-time.After(time.Second):
+		t.Fatal("GoContext isn't cancelled by its context")
+	}
+	select {
+	case err = <-waitCancelled:
+		_assert(err == context.Canceled, "expect method context cancelled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("server kept handling the cancelled request")
+	}
+}
+
+func TestClientMetadata(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var trailer map[string]string
+	cli.Use(func(ctx context.Context, call *Call, invoker Invoker) error {
+		ctx = WithMetadata(ctx, map[string]string{"trace-id": "42"})
+		err := invoker(ctx, call)
+		trailer = call.Trailer
+		return err
+	})
+
+	ctx := WithMetadata(context.Background(), map[string]string{"tenant": "acme"})
+	var reply string
+	err = cli.Call(ctx, "Foo.Meta", &Args{}, &reply)
+	_assert(err == nil && reply == "acme", "Foo.Meta: reply %q, err %v", reply, err)
+	_assert(trailer["handled-by"] == "foo", "expect trailer from server, got %v", trailer)
+	_assert(MetadataFromContext(ctx)["trace-id"] == "", "WithMetadata shouldn't modify the parent context")
+}
+
+func TestClientErrors(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var reply int
+	err = cli.Call(context.Background(), "Foo.Unknown", &Args{}, &reply)
+	_assert(errors.Is(err, nami.ErrNotFound), "expect not found error, got %v", err)
+
+	err = cli.Call(context.Background(), "Foo.Validate", &Args{Num1: -1}, &reply)
+	var e *nami.Error
+	_assert(errors.As(err, &e) && e.Code == nami.CodeInvalidArgument, "expect invalid argument error, got %v", err)
+	_assert(e.Message == "Num1 is -1%" && e.Details["field"] == "Num1", "wrong error message or details: %q %v", e.Message, e.Details)
+
+	err = cli.Call(context.Background(), "Foo.Fail", &Args{}, &reply)
+	_assert(errors.As(err, &e) && e.Code == nami.CodeUnknown, "expect unknown error, got %v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = cli.Call(ctx, "Foo.Sleep", &Args{Num1: 10}, &reply)
+	_assert(errors.Is(err, nami.ErrCanceled) && errors.Is(err, context.Canceled), "expect canceled error, got %v", err)
+}
+
+func TestClientStream(t *testing.T) {
+	addr := startServer(t)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.FrameType, codec.MsgpackType} {
+		cli, err := Dial("tcp", addr, &nami.Option{CodecType: typ, StreamWindow: 4})
+		_assert(err == nil, "dial with %s failed: %v", typ, err)
+
+		st, err := cli.Stream(context.Background(), "Foo.Count", &Args{Num1: 0, Num2: 20}, new(int))
+		_assert(err == nil, "open stream with %s failed: %v", typ, err)
+		var got []int
+		for {
+			var n int
+			if err = st.Recv(&n); err != nil {
+				break
+			}
+			got = append(got, n)
+		}
+		_assert(err == io.EOF && len(got) == 20 && got[19] == 19, "Foo.Count with %s: got %v, err %v", typ, got, err)
+		_assert(st.Trailer()["count"] == "20", "expect trailer of stream with %s, got %v", typ, st.Trailer())
+
+		st, _ = cli.Stream(context.Background(), "Foo.Count", &Args{Num1: 1, Num2: 0}, new(int))
+		err = st.Recv(new(int))
+		_assert(errors.Is(err, nami.ErrInvalidArgument), "expect stream error with %s, got %v", typ, err)
+
+		var reply int
+		err = cli.Call(context.Background(), "Foo.Count", &Args{}, &reply)
+		_assert(errors.Is(err, nami.ErrInvalidArgument), "expect calling a streaming method to fail, got %v", err)
+		cli.Close()
+	}
+}
+
+func TestClientTypedStream(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	st, err := cli.Stream(context.Background(), "Foo.Squares", &Args{Num1: 4}, new(int))
+	_assert(err == nil, "open stream failed: %v", err)
+	var got []int
+	for {
+		var n int
+		if err = st.Recv(&n); err != nil {
+			break
+		}
+		got = append(got, n)
+	}
+	_assert(err == io.EOF && len(got) == 4 && got[3] == 9, "Foo.Squares: got %v, err %v", got, err)
+
+	st, _ = cli.Stream(context.Background(), "Foo.Squares", &Args{Num2: 1}, new(int))
+	err = st.Recv(new(int))
+	_assert(errors.Is(err, nami.ErrInternal), "expect sending a string on a stream of int to fail, got %v", err)
+}
+
+func TestClientStreamFlowControl(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr, &nami.Option{StreamWindow: 4})
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	st, err := cli.Stream(context.Background(), "Foo.Forever", &Args{}, new(int))
+	_assert(err == nil, "open stream failed: %v", err)
+	var n int
+	for i := 0; i < 10; i++ {
+		err = st.Recv(&n)
+		_assert(err == nil && n == i, "Foo.Forever: got %d, err %v", n, err)
+	}
+
+	// the server is blocked by the window while the client doesn't receive
+	time.Sleep(time.Millisecond * 50)
+	st.Close()
+	select {
+	case sent := <-countSent:
+		_assert(sent <= 10+4, "server sent %d messages, more than the window allows", sent)
+	case <-time.After(time.Second):
+		t.Fatal("server kept streaming after the stream was closed")
+	}
+	err = st.Recv(&n)
+	_assert(errors.Is(err, nami.ErrCanceled), "expect closed stream to fail, got %v", err)
+}
+
+func TestClientSendStream(t *testing.T) {
+	addr := startServer(t)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.FrameType, codec.MsgpackType} {
+		cli, err := Dial("tcp", addr, &nami.Option{CodecType: typ, StreamWindow: 4})
+		_assert(err == nil, "dial with %s failed: %v", typ, err)
+
+		var total int
+		st, err := cli.OpenStream(context.Background(), "Foo.Total", &total)
+		_assert(err == nil, "open stream with %s failed: %v", typ, err)
+		for i := 1; i <= 20; i++ {
+			err = st.Send(i)
+			_assert(err == nil, "send with %s failed: %v", typ, err)
+		}
+		err = st.CloseAndRecv(&total)
+		_assert(err == nil && total == 210, "Foo.Total with %s: got %d, err %v", typ, total, err)
+
+		st, err = cli.OpenStream(context.Background(), "Foo.Double", new(int))
+		_assert(err == nil, "open stream with %s failed: %v", typ, err)
+		for i := 0; i < 20; i++ {
+			var n int
+			err = st.Send(i)
+			_assert(err == nil, "send with %s failed: %v", typ, err)
+			err = st.Recv(&n)
+			_assert(err == nil && n == i*2, "Foo.Double with %s: got %d, err %v", typ, n, err)
+		}
+		_ = st.CloseSend()
+		err = st.Recv(new(int))
+		_assert(err == io.EOF, "expect bidi stream to end with %s, got %v", typ, err)
+		cli.Close()
+	}
+}
+
+func TestClientSendStreamFlowControl(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr, &nami.Option{StreamWindow: 4})
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	st, err := cli.OpenStream(ctx, "Foo.Idle", new(int))
+	_assert(err == nil, "open stream failed: %v", err)
+	<-idleStarted
+
+	// the server doesn't receive, so sending blocks once the window is used up
+	sent := 0
+	for ; sent < 10; sent++ {
+		if err = st.Send(sent); err != nil {
+			break
+		}
+	}
+	_assert(sent == 4 && errors.Is(err, nami.ErrDeadlineExceeded), "expect sending to block after 4 messages, sent %d, err %v", sent, err)
+}
+
+func TestClientStreamWindowTooLarge(t *testing.T) {
+	addr := startServer(t)
+	_, err := Dial("tcp", addr, &nami.Option{StreamWindow: 1 << 62})
+	_assert(errors.Is(err, nami.ErrInvalidArgument), "expect a stream window above the max to be refused, got %v", err)
+
+	cli, err := Dial("tcp", addr, &nami.Option{StreamWindow: nami.MaxStreamWindow})
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+	var reply int
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the server to keep serving, got %d, err %v", reply, err)
+}
+
+func TestClientNotify(t *testing.T) {
+	server, addr := newServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	err = cli.Notify("Foo.Record", &Args{Num1: 7})
+	_assert(err == nil, "notify failed: %v", err)
+	select {
+	case n := <-recorded:
+		_assert(n == 7, "expect Foo.Record to get 7, got %d", n)
+	case <-time.After(time.Second):
+		t.Fatal("Foo.Record isn't called by notify")
+	}
+
+	// failed notifies aren't replied, the calls after them are unaffected
+	_assert(cli.Notify("Foo.Fail", &Args{}) == nil, "notify Foo.Fail failed")
+	_assert(cli.Notify("Foo.Unknown", &Args{}) == nil, "notify Foo.Unknown failed")
+	var reply int
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "call after notify: got %d, err %v", reply, err)
+	_assert(len(cli.(*Client).pending) == 0, "notify shouldn't keep calls pending")
+	// the unknown method is rejected while reading, before the call after it
+	_assert(server.NumNotifyErrors() == 1, "expect the misrouted notify to be counted, got %d", server.NumNotifyErrors())
+
+	cli.Close()
+	_assert(errors.Is(cli.Notify("Foo.Record", &Args{}), nami.ErrUnavailable), "expect notify on closed client to fail")
+}
+
+func TestClientCallBatch(t *testing.T) {
+	addr := startServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var batch Batch
+	replies := make([]int, 3)
+	batch.Add("Foo.Meet", &Args{Num1: 1}, &replies[0])
+	batch.Add("Foo.Meet", &Args{Num1: 2}, &replies[1])
+	batch.Add("Foo.Fail", &Args{}, &replies[2])
+	errs := cli.CallBatch(context.Background(), &batch)
+	_assert(errs[0] == nil && errs[1] == nil && replies[0] == 1 && replies[1] == 2, "expect batched calls to be handled concurrently, got %v, errs %v", replies, errs)
+	_assert(errs[2] != nil && strings.Contains(errs[2].Error(), "always fail"), "expect batched Foo.Fail to fail, got %v", errs[2])
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	batch = Batch{}
+	batch.Add("Foo.Sum", &Args{Num1: 1, Num2: 2}, &replies[0])
+	batch.Add("Foo.Sleep", &Args{Num1: 200}, &replies[1])
+	errs = cli.CallBatch(ctx, &batch)
+	_assert(errs[0] == nil && replies[0] == 3, "batched Foo.Sum: got %d, err %v", replies[0], errs[0])
+	_assert(errors.Is(errs[1], nami.ErrDeadlineExceeded), "expect batched Foo.Sleep to time out, got %v", errs[1])
+}
+
+func TestClientMetricsAbandoned(t *testing.T) {
+	addr := startServer(t)
+	metrics := nami.NewClientMetrics()
+	cli, err := Dial("tcp", addr, &nami.Option{Metrics: metrics})
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	// a stream closed early
+	st, err := cli.Stream(context.Background(), "Foo.Count", &Args{Num1: 0, Num2: 1 << 30}, new(int))
+	_assert(err == nil, "stream failed: %v", err)
+	_assert(st.Recv(new(int)) == nil, "recv failed")
+	st.Close()
+
+	// a batched call timing out
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	var batch Batch
+	batch.Add("Foo.Sleep", &Args{Num1: 200}, new(int))
+	errs := cli.CallBatch(ctx, &batch)
+	_assert(errors.Is(errs[0], nami.ErrDeadlineExceeded), "expect batched Foo.Sleep to time out, got %v", errs[0])
+
+	var b strings.Builder
+	_, _ = metrics.WriteTo(&b)
+	for _, want := range []string{
+		`nami_client_calls_total{method="Foo.Count"} 1`,
+		`nami_client_errors_total{method="Foo.Count",code="Canceled"} 1`,
+		`nami_client_in_flight{method="Foo.Count"} 0`,
+		`nami_client_calls_total{method="Foo.Sleep"} 1`,
+		`nami_client_errors_total{method="Foo.Sleep",code="DeadlineExceeded"} 1`,
+		`nami_client_in_flight{method="Foo.Sleep"} 0`,
+	} {
+		_assert(strings.Contains(b.String(), want), "expect %q in client metrics:\n%s", want, b.String())
+	}
+}
+
+func TestClientServerLimits(t *testing.T) {
+	server, addr := newServer(t)
+	server.SetLimits(nami.Limits{MaxInFlightPerMethod: map[string]int{"Foo.Sleep": 1}})
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var reply int
+	first := cli.Go("Foo.Sleep", &Args{Num1: 100}, new(int), nil)
+	err = cli.Call(context.Background(), "Foo.Sleep", &Args{Num1: 10}, &reply)
+	_assert(errors.Is(err, nami.ErrOverloaded), "expect Foo.Sleep beyond its limit to be rejected, got %v", err)
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect other methods to be unlimited, got %d, err %v", reply, err)
+	_assert((<-first.Done).Error == nil, "Foo.Sleep within the limit failed: %v", first.Error)
+
+	// a request waits in the queue until its slot is released or the queue timeout
+	server.SetLimits(nami.Limits{MaxInFlight: 1, QueueSize: 1, QueueTimeout: time.Millisecond * 50})
+	cli2, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli2.Close()
+	first = cli2.Go("Foo.Sleep", &Args{Num1: 100}, new(int), nil)
+	queued := cli2.Go("Foo.Sleep", &Args{Num1: 10}, new(int), nil)
+	err = cli2.Call(context.Background(), "Foo.Sum", &Args{}, &reply)
+	_assert(errors.Is(err, nami.ErrOverloaded), "expect a request beyond the full queue to be rejected, got %v", err)
+	_assert(errors.Is((<-queued.Done).Error, nami.ErrOverloaded), "expect queued request to time out, got %v", queued.Error)
+	_assert((<-first.Done).Error == nil, "Foo.Sleep within the limit failed: %v", first.Error)
+
+	server.SetLimits(nami.Limits{MaxInFlightPerConn: 1, QueueSize: 1})
+	cli3, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli3.Close()
+	first = cli3.Go("Foo.Sleep", &Args{Num1: 50}, new(int), nil)
+	err = cli3.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect queued request to be handled, got %d, err %v", reply, err)
+	_assert((<-first.Done).Error == nil, "Foo.Sleep within the limit failed: %v", first.Error)
+}
+
+func TestClientServerLimitsTimeout(t *testing.T) {
+	server, addr := newServer(t)
+	server.SetLimits(nami.Limits{MaxInFlight: 1})
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	var reply int
+	err = cli.Call(ctx, "Foo.Sleep", &Args{Num1: 300}, &reply)
+	_assert(errors.Is(err, nami.ErrDeadlineExceeded), "expect Foo.Sleep to time out, got %v", err)
+	// Foo.Sleep still runs after its timeout, it keeps its slot until it returns
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, nami.ErrOverloaded), "expect the slot of the timed out Foo.Sleep to be held, got %v", err)
+
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(err == nil && reply == 3, "expect the slot to be released once Foo.Sleep returns, got %d, err %v", reply, err)
+}
+
+func TestClientServerLimitsChanged(t *testing.T) {
+	server, addr := newServer(t)
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+	var reply int
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect unlimited call to succeed, got %d, err %v", reply, err)
+
+	// the limits apply to the connections already served
+	server.SetLimits(nami.Limits{MaxInFlight: 2, MaxInFlightPerMethod: map[string]int{"Foo.Sleep": 1}, QueueSize: 1})
+	first := cli.Go("Foo.Sleep", &Args{Num1: 100}, new(int), nil)
+	queued := cli.Go("Foo.Sleep", &Args{Num1: 10}, new(int), nil)
+	// the queued Foo.Sleep waits for its method without holding a slot of the server
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect a request of another method to be handled, got %d, err %v", reply, err)
+	err = cli.Call(context.Background(), "Foo.Sleep", &Args{Num1: 10}, &reply)
+	_assert(errors.Is(err, nami.ErrOverloaded), "expect Foo.Sleep beyond the full queue to be rejected, got %v", err)
+	_assert((<-first.Done).Error == nil, "Foo.Sleep within the limit failed: %v", first.Error)
+	_assert((<-queued.Done).Error == nil, "queued Foo.Sleep failed: %v", queued.Error)
+}
+
+func TestClientRateLimited(t *testing.T) {
+	server, addr := newServer(t)
+	server.AddRateLimiter(nami.NewRateLimiter("tenant", nami.KeyByMetadata("tenant"), 0.1, 1))
+	cli, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var reply int
+	ctx := WithMetadata(context.Background(), map[string]string{"tenant": "acme"})
+	err = cli.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the first call of tenant to succeed, got %d, err %v", reply, err)
+	err = cli.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, nami.ErrRateLimited) && !errors.Is(err, nami.ErrOverloaded), "expect the tenant to be rate limited, got %v", err)
+	err = cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "expect calls without tenant to be unlimited, got %v", err)
+}
+
+func TestClientMetrics(t *testing.T) {
+	server, addr := newServer(t)
+	metrics := nami.NewClientMetrics()
+	cli, err := Dial("tcp", addr, &nami.Option{Metrics: metrics})
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli.Close()
+
+	var reply int
+	_assert(cli.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil, "call failed")
+	_assert(cli.Call(context.Background(), "Foo.Fail", &Args{}, &reply) != nil, "expect Foo.Fail to fail")
+	_assert(cli.Call(context.Background(), "Foo.Missing", &Args{}, &reply) != nil, "expect an unknown method to fail")
+
+	scrape := func(m *nami.Metrics) string {
+		var b strings.Builder
+		_, err := m.WriteTo(&b)
+		_assert(err == nil, "write metrics failed: %v", err)
+		return b.String()
+	}
+	for _, want := range []string{
+		`nami_client_calls_total{method="Foo.Sum"} 1`,
+		`nami_client_errors_total{method="Foo.Fail",code="Unknown"} 1`,
+		`nami_client_errors_total{method="Foo.Missing",code="NotFound"} 1`,
+		`nami_client_call_duration_seconds_count{method="Foo.Sum"} 1`,
+		`nami_client_in_flight{method="Foo.Sum"} 0`,
+		`nami_client_connections 1`,
+	} {
+		_assert(strings.Contains(scrape(metrics), want), "expect %q in client metrics:\n%s", want, scrape(metrics))
+	}
+	for _, want := range []string{
+		`nami_server_calls_total{method="Foo.Sum"} 1`,
+		`nami_server_errors_total{method="unknown",code="NotFound"} 1`,
+		`nami_server_call_duration_seconds_bucket{method="Foo.Fail",le="+Inf"} 1`,
+		`nami_server_connections_total 1`,
+	} {
+		_assert(strings.Contains(scrape(server.Metrics()), want), "expect %q in server metrics:\n%s", want, scrape(server.Metrics()))
+	}
+	_assert(!strings.Contains(scrape(metrics), "nami_client_received_bytes_total 0\n"), "expect received bytes to be counted")
+
+	cli.Close()
+	_assert(strings.Contains(scrape(metrics), "nami_client_connections 0\n"), "expect the connection to be closed")
+	_assert(cli.Call(context.Background(), "Foo.Sum", &Args{}, &reply) != nil, "expect a closed client to refuse calls")
+	_assert(strings.Contains(scrape(metrics), `nami_client_calls_total{method="Foo.Sum"} 1`), "expect calls refused by a closed client not to be recorded:\n%s", scrape(metrics))
+
+	// the wait in the queue of the Limits isn't part of the latency
+	server.SetLimits(nami.Limits{MaxInFlight: 1, QueueSize: 1})
+	cli2, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer cli2.Close()
+	first := cli2.Go("Foo.Sleep", &Args{Num1: 200}, new(int), nil)
+	_assert(cli2.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil, "queued call failed")
+	_assert((<-first.Done).Error == nil, "Foo.Sleep failed: %v", first.Error)
+	want := `nami_server_call_duration_seconds_bucket{method="Foo.Sum",le="0.1"} 2`
+	_assert(strings.Contains(scrape(server.Metrics()), want), "expect %q in server metrics:\n%s", want, scrape(server.Metrics()))
+}
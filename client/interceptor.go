@@ -0,0 +1,21 @@
+package client
+
+import "context"
+
+// Invoker sends call and waits for its reply
+type Invoker func(ctx context.Context, call *Call) error
+
+// Interceptor intercepts an outgoing call. It may mutate the call or ctx before invoking,
+// invoke several times (e.g. retries), or rewrite the returned error.
+type Interceptor func(ctx context.Context, call *Call, invoker Invoker) error
+
+// ChainInterceptors wraps invoker with interceptors, the first one is the outermost
+func ChainInterceptors(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+
+	"github.com/xeasy/nami"
+)
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying md, merged with the metadata ctx already carries.
+// Calls made with the context send the metadata to the server along with the request.
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	old := MetadataFromContext(ctx)
+	merged := make(map[string]string, len(old)+len(md))
+	for k, v := range old {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the outgoing metadata carried by ctx, it mustn't be modified
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+// WithBearerToken returns a copy of ctx whose calls carry token for the server's BearerAuthenticator
+func WithBearerToken(ctx context.Context, token string) context.Context {
+	return WithMetadata(ctx, map[string]string{nami.AuthorizationKey: "Bearer " + token})
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/xeasy/nami"
+	"github.com/xeasy/nami/codec"
+)
+
+// ClientStream receives the messages of a server-streaming call, and sends the messages
+// of a client-streaming or bidirectional streaming call
+type ClientStream struct {
+	c         *Client
+	call      *Call
+	ctx       context.Context
+	replyType reflect.Type
+	window    int
+	consumed  int // messages received since the last ack
+
+	mu      sync.Mutex // protect following
+	msgs    chan reflect.Value
+	err     error // final error, set before msgs is closed
+	closed  bool
+	credits int           // messages the server has room for
+	acked   chan struct{} // notified when credits are granted
+	done    chan struct{} // closed once the stream ends
+	sendEnd bool
+}
+
+// Stream calls a server-streaming method, reply is a pointer whose type is the type of the messages.
+// The stream is cancelled when ctx is done or Close is called. Streams don't go through the
+// interceptors installed by Use, which wrap a single request and reply.
+func (c *Client) Stream(ctx context.Context, serviceMethod string, args any, reply any) (*ClientStream, error) {
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, fmt.Errorf("rpc client: stream reply must be a non-nil pointer, got %T", reply)
+	}
+
+	window := c.opt.StreamWindowSize()
+	st := &ClientStream{
+		c:         c,
+		ctx:       ctx,
+		replyType: rv.Type().Elem(),
+		window:    window,
+		msgs:      make(chan reflect.Value, window),
+		credits:   window,
+		acked:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	st.call = &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		kind:          codec.KindStream,
+		stream:        st,
+	}
+	st.call.deadline, _ = ctx.Deadline()
+	st.call.metadata = MetadataFromContext(ctx)
+	c.send(st.call)
+	return st, nil
+}
+
+// OpenStream calls a client-streaming or bidirectional streaming method, whose messages are sent by Send.
+// reply is a pointer whose type is the type of the reply, or of the messages sent by server.
+// As Stream, it doesn't go through the interceptors.
+func (c *Client) OpenStream(ctx context.Context, serviceMethod string, reply any) (*ClientStream, error) {
+	return c.Stream(ctx, serviceMethod, struct{}{}, reply)
+}
+
+// Send sends msg to server, it blocks while the server has no room for more messages
+func (st *ClientStream) Send(msg any) error {
+	data, err := codec.MarshalerOf(st.c.cc).MarshalBody(msg)
+	if err != nil {
+		return err
+	}
+	for {
+		st.mu.Lock()
+		if st.closed {
+			err := st.err
+			st.mu.Unlock()
+			if err == io.EOF {
+				err = errors.New("rpc client: send on ended stream")
+			}
+			return err
+		}
+		if st.sendEnd {
+			st.mu.Unlock()
+			return errors.New("rpc client: send after CloseSend")
+		}
+		if st.credits > 0 {
+			st.credits--
+			st.mu.Unlock()
+			break
+		}
+		st.mu.Unlock()
+
+		select {
+		case <-st.acked:
+		case <-st.done:
+		case <-st.ctx.Done():
+			return st.ctxError()
+		}
+	}
+	return st.c.sendControl(codec.KindStreamMsg, st.call.Seq, data)
+}
+
+// CloseSend tells server there are no more messages, the replies can still be received
+func (st *ClientStream) CloseSend() error {
+	st.mu.Lock()
+	if st.sendEnd || st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.sendEnd = true
+	st.mu.Unlock()
+	return st.c.sendControl(codec.KindStreamEnd, st.call.Seq, struct{}{})
+}
+
+// CloseAndRecv closes the sending side of a client-streaming call, and waits for its reply
+func (st *ClientStream) CloseAndRecv(reply any) error {
+	if err := st.CloseSend(); err != nil {
+		return err
+	}
+	if err := st.Recv(reply); err != nil {
+		if err == io.EOF {
+			err = errors.New("rpc client: stream ended without reply")
+		}
+		return err
+	}
+	// wait for the end of the stream, it carries the trailers
+	select {
+	case <-st.done:
+	case <-st.ctx.Done():
+		return st.ctxError()
+	}
+	if st.err != io.EOF {
+		return st.err
+	}
+	return nil
+}
+
+// grant gives the stream n more credits to send
+func (st *ClientStream) grant(n int) {
+	st.mu.Lock()
+	st.credits += n
+	st.mu.Unlock()
+	select {
+	case st.acked <- struct{}{}:
+	default:
+	}
+}
+
+// Recv receives the next message into reply, which must be of the same type as the one given to Stream.
+// It returns io.EOF when the stream ends successfully.
+func (st *ClientStream) Recv(reply any) error {
+	select {
+	case v, ok := <-st.msgs:
+		if !ok {
+			return st.err
+		}
+		reflect.ValueOf(reply).Elem().Set(v.Elem())
+		st.ack()
+		return nil
+	case <-st.ctx.Done():
+		return st.ctxError()
+	}
+}
+
+func (st *ClientStream) ctxError() error {
+	st.abort(st.ctx.Err())
+	return nami.Errorf(nami.ContextCode(st.ctx.Err()), "rpc client: stream failed: %w", st.ctx.Err())
+}
+
+// Close cancels the stream if it hasn't ended, messages not received yet are dropped
+func (st *ClientStream) Close() error {
+	st.abort(context.Canceled)
+	return nil
+}
+
+// Trailer returns the trailers attached by server, it's available once Recv returned io.EOF
+func (st *ClientStream) Trailer() map[string]string {
+	return st.call.Trailer
+}
+
+// ack grants server credits for the consumed messages, half a window at a time
+func (st *ClientStream) ack() {
+	st.consumed++
+	if st.consumed < (st.window+1)/2 {
+		return
+	}
+	if err := st.c.sendControl(codec.KindStreamAck, st.call.Seq, st.consumed); err != nil {
+		st.c.opt.Log().Warn("rpc client: send stream ack error", "seq", st.call.Seq, "method", st.call.ServiceMethod, "err", err)
+	}
+	st.consumed = 0
+}
+
+// abort gives up the stream for reason, and tells server to stop it if it's still running
+func (st *ClientStream) abort(reason error) {
+	if st.c.removeCall(st.call.Seq) == nil {
+		return
+	}
+	if reason != context.DeadlineExceeded {
+		st.c.sendCancel(st.call.Seq)
+	}
+
+	err := nami.Errorf(nami.ContextCode(reason), "rpc client: stream closed: %w", reason)
+	st.mu.Lock()
+	for len(st.msgs) > 0 {
+		<-st.msgs
+	}
+	st.closeLocked(err)
+	st.mu.Unlock()
+
+	// no end comes from server anymore, finish the call on its own
+	st.call.Error = err
+	st.call.done()
+}
+
+// receive reads a message of the stream from cc
+func (st *ClientStream) receive(cc codec.Codec) error {
+	v := reflect.New(st.replyType)
+	if err := cc.ReadBody(v.Interface()); err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return nil
+	}
+	select {
+	case st.msgs <- v:
+	default:
+		// server sent more messages than granted
+		st.closeLocked(errors.New("rpc client: stream window overflow"))
+	}
+	return nil
+}
+
+// finish ends the stream with err, io.EOF if err is nil
+func (st *ClientStream) finish(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.closeLocked(err)
+}
+
+func (st *ClientStream) closeLocked(err error) {
+	if st.closed {
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	st.err = err
+	st.closed = true
+	close(st.msgs)
+	close(st.done)
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xeasy/nami"
+)
+
+// newCert returns a certificate for cn signed by parent, or self-signed if parent is nil
+func newCert(t *testing.T, cn string, parent *tls.Certificate, isCA bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func (f Foo) Whoami(ctx context.Context, args Args, reply *string) error {
+	info, _ := nami.RequestInfoFromContext(ctx)
+	if info.PeerCertificate != nil {
+		*reply = info.PeerCertificate.Subject.CommonName
+	}
+	return nil
+}
+
+func startTLSServer(t *testing.T, config *tls.Config) string {
+	var foo Foo
+	server := nami.NewServer()
+	_ = server.Regiest(&foo)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error: ", err)
+	}
+	go server.AcceptTLS(l, config)
+	t.Cleanup(func() { server.Close() })
+	return l.Addr().String()
+}
+
+func TestClientTLS(t *testing.T) {
+	ca := newCert(t, "test ca", nil, true)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := newCert(t, "server", &ca, false)
+	clientCert := newCert(t, "alice", &ca, false)
+
+	addr := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+
+	// server-only TLS, the client has no identity
+	cli, err := DialTLS("tcp", addr, &tls.Config{RootCAs: pool})
+	_assert(err == nil, "dial tls failed: %v", err)
+	var name string
+	err = cli.Call(context.Background(), "Foo.Whoami", &Args{}, &name)
+	_assert(err == nil && name == "", "expect no peer identity, got %q, err %v", name, err)
+	cli.Close()
+
+	// mutual TLS through XDial
+	cli, err = XDial("tls@"+addr, &nami.Option{TLSConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}}})
+	_assert(err == nil, "xdial tls failed: %v", err)
+	err = cli.Call(context.Background(), "Foo.Whoami", &Args{}, &name)
+	_assert(err == nil && name == "alice", "expect peer identity alice, got %q, err %v", name, err)
+	cli.Close()
+
+	// the server certificate isn't trusted without the CA
+	_, err = DialTLS("tcp", addr, &tls.Config{})
+	_assert(err != nil, "expect dialing an untrusted server to fail")
+}
@@ -1,6 +1,9 @@
 package codec
 
-import "io"
+import (
+	"io"
+	"sync"
+)
 
 type Codec interface {
 	io.Closer
@@ -10,6 +13,40 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// BatchWriter is implemented by codecs able to write several messages with a single flush,
+// a failure partway through is reported by a *BatchError
+type BatchWriter interface {
+	WriteBatch(headers []*Header, bodies []interface{}) error
+}
+
+// BatchError is returned by WriteBatch when the message at Index fails to be written,
+// the messages before it have been written and those after it haven't.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// WriteBatch writes the messages headers[i], bodies[i] in order, flushing once if cc is a BatchWriter
+func WriteBatch(cc Codec, headers []*Header, bodies []interface{}) error {
+	if bw, ok := cc.(BatchWriter); ok {
+		return bw.WriteBatch(headers, bodies)
+	}
+	for i := range headers {
+		if err := cc.Write(headers[i], bodies[i]); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 type Type string
@@ -17,9 +54,69 @@ type Type string
 const GobType Type = "application/god"
 const JsonType Type = "application/json"
 
-var NewCodecFuncMap map[Type]NewCodecFunc
+var (
+	mu        sync.RWMutex // protect following
+	codecs    = make(map[Type]NewCodecFunc)
+	codecList []Type // registration order
+
+	legacyOnce sync.Once
+	legacy     map[Type]NewCodecFunc // copied from NewCodecFuncMap on first Lookup
+)
+
+// NewCodecFuncMap holds the registered codecs, it's filled by Register.
+//
+// Deprecated: use Register and Lookup, which are safe for concurrent use. Codecs
+// put in the map directly, before the first Lookup (e.g. in an init func), are still
+// found by Lookup, but aren't offered by Types.
+var NewCodecFuncMap = make(map[Type]NewCodecFunc)
 
 func init() {
-	NewCodecFuncMap = make(map[Type]NewCodecFunc)
-	NewCodecFuncMap[GobType] = NewGobCodec
+	Register(GobType, NewGobCodec)
+	Register(JsonType, NewJsonCodec)
+	Register(FrameType, NewFrameCodec)
+	Register(MsgpackType, NewMsgpackCodec)
+}
+
+// Register makes a codec available by the provided type, registering the same type twice replaces the former one
+func Register(typ Type, f NewCodecFunc) {
+	if f == nil {
+		panic("rpc codec: Register codec func is nil")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := codecs[typ]; !dup {
+		codecList = append(codecList, typ)
+	}
+	codecs[typ] = f
+	NewCodecFuncMap[typ] = f
+}
+
+// Lookup returns the codec func registered for typ, or nil if there is none
+func Lookup(typ Type) NewCodecFunc {
+	legacyOnce.Do(copyLegacy)
+	mu.RLock()
+	defer mu.RUnlock()
+	if f := codecs[typ]; f != nil {
+		return f
+	}
+	return legacy[typ]
+}
+
+// copyLegacy takes the codecs put in NewCodecFuncMap, which isn't read afterwards
+func copyLegacy() {
+	mu.Lock()
+	defer mu.Unlock()
+	legacy = make(map[Type]NewCodecFunc, len(NewCodecFuncMap))
+	for typ, f := range NewCodecFuncMap {
+		legacy[typ] = f
+	}
+}
+
+// Types returns all registered codec types in registration order
+func Types() []Type {
+	mu.RLock()
+	defer mu.RUnlock()
+	types := make([]Type, len(codecList))
+	copy(types, codecList)
+	return types
 }
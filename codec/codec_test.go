@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// countingConn records how many times it's written to
+type countingConn struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func (c *countingConn) Close() error { return nil }
+
+func TestWriteBatch(t *testing.T) {
+	for _, typ := range Types() {
+		conn := &countingConn{}
+		cc := NewCompressCodec(Lookup(typ)(conn), GzipCompress, 0)
+		headers := []*Header{{Seq: 1}, {Seq: 2}, {Seq: 3}}
+		bodies := []interface{}{1, make([]int, DefaultCompressThreshold), 3}
+		if err := WriteBatch(cc, headers, bodies); err != nil {
+			t.Fatalf("%s: write batch fail: %v", typ, err)
+		}
+		if conn.writes != 1 {
+			t.Fatalf("%s: expect a batch to be written at once, got %d writes", typ, conn.writes)
+		}
+
+		r := NewCompressCodec(Lookup(typ)(struct {
+			io.Reader
+			io.WriteCloser
+		}{&conn.Buffer, conn}), NoCompress, 0)
+		for seq := uint64(1); seq <= 3; seq++ {
+			var h Header
+			var body interface{}
+			if err := r.ReadHeader(&h); err != nil || h.Seq != seq {
+				t.Fatalf("%s: expect header %d, got %+v, %v", typ, seq, h, err)
+			}
+			if seq == 2 {
+				body = new([]int)
+			} else {
+				body = new(int)
+			}
+			if err := r.ReadBody(body); err != nil {
+				t.Fatalf("%s: read body %d fail: %v", typ, seq, err)
+			}
+		}
+	}
+}
+
+func TestWriteBatchPartial(t *testing.T) {
+	for _, typ := range Types() {
+		for _, compress := range []CompressType{NoCompress, GzipCompress} {
+			conn := &countingConn{}
+			var cc Codec = Lookup(typ)(conn)
+			if compress != NoCompress {
+				cc = NewCompressCodec(cc, compress, 0)
+			}
+			headers := []*Header{{Seq: 1}, {Seq: 2}, {Seq: 3}}
+			bodies := []interface{}{1, make(chan int), 3}
+			var be *BatchError
+			if err := WriteBatch(cc, headers, bodies); !errors.As(err, &be) || be.Index != 1 {
+				t.Fatalf("%s/%s: expect a BatchError at 1, got %v", typ, compress, err)
+			}
+			if err := cc.Write(&Header{Seq: 4}, 4); err != nil {
+				t.Fatalf("%s/%s: write after the batch fail: %v", typ, compress, err)
+			}
+
+			// nothing of the failing message is written, the next one follows the first
+			r := Lookup(typ)(struct {
+				io.Reader
+				io.WriteCloser
+			}{&conn.Buffer, conn})
+			for _, seq := range []uint64{1, 4} {
+				var h Header
+				var body int
+				if err := r.ReadHeader(&h); err != nil || h.Seq != seq {
+					t.Fatalf("%s/%s: expect header %d, got %+v, %v", typ, compress, seq, h, err)
+				}
+				if err := r.ReadBody(&body); err != nil || body != int(seq) {
+					t.Fatalf("%s/%s: expect body %d, got %d, %v", typ, compress, seq, body, err)
+				}
+			}
+		}
+	}
+}
+
+// codecs are put in the deprecated map in an init func, before any Lookup
+func init() {
+	NewCodecFuncMap["application/legacy"] = NewGobCodec
+}
+
+func TestNewCodecFuncMap(t *testing.T) {
+	if NewCodecFuncMap[GobType] == nil {
+		t.Fatal("expect registered codecs to be in NewCodecFuncMap")
+	}
+	if Lookup("application/legacy") == nil {
+		t.Fatal("expect codecs put in NewCodecFuncMap to be found")
+	}
+	for _, typ := range Types() {
+		if typ == "application/legacy" {
+			t.Fatal("expect codecs put in NewCodecFuncMap not to be offered")
+		}
+	}
+}
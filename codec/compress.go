@@ -0,0 +1,217 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type CompressType string
+
+const (
+	NoCompress    CompressType = ""
+	GzipCompress  CompressType = "gzip"
+	FlateCompress CompressType = "flate" // flate at best speed, cheaper than gzip
+)
+
+// DefaultCompressThreshold is the body size below which bodies are sent uncompressed
+const DefaultCompressThreshold = 1024
+
+// DefaultMaxDecompressSize is the max size of a body once decompressed, so a small
+// compressed body can't exhaust the memory of its reader
+const DefaultMaxDecompressSize = 64 << 20
+
+var ErrBodyTooLarge = errors.New("rpc codec: decompressed body too large")
+
+type compressor struct {
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[CompressType]compressor{
+	GzipCompress: {
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	FlateCompress: {
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.BestSpeed) },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+	},
+}
+
+// IsSupportedCompress reports whether typ is a known compression
+func IsSupportedCompress(typ CompressType) bool {
+	_, ok := compressors[typ]
+	return typ == NoCompress || ok
+}
+
+// BodyMarshaler is implemented by codecs able to encode a body on its own,
+// CompressCodec uses it to get the bytes to compress. Gob is used otherwise.
+type BodyMarshaler interface {
+	MarshalBody(body interface{}) ([]byte, error)
+	UnmarshalBody(data []byte, body interface{}) error
+}
+
+// CompressCodec wraps a Codec, compressing bodies larger than threshold.
+// Compressed bodies are sent as a byte slice and flagged by Header.Compress.
+type CompressCodec struct {
+	Codec
+	typ       CompressType
+	threshold int
+	maxSize   int // of a decompressed body
+	marshaler BodyMarshaler
+	compress  CompressType // compression of the body to be read next
+}
+
+// NewCompressCodec wraps cc, bodies are compressed by typ when their encoding reaches threshold.
+// Compressed bodies are always readable whatever typ is, up to DefaultMaxDecompressSize.
+func NewCompressCodec(cc Codec, typ CompressType, threshold int) Codec {
+	return NewCompressCodecSize(cc, typ, threshold, DefaultMaxDecompressSize)
+}
+
+// NewCompressCodecSize is NewCompressCodec refusing bodies larger than maxSize once decompressed
+func NewCompressCodecSize(cc Codec, typ CompressType, threshold, maxSize int) Codec {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressSize
+	}
+	return &CompressCodec{Codec: cc, typ: typ, threshold: threshold, maxSize: maxSize, marshaler: MarshalerOf(cc)}
+}
+
+// MarshalerOf returns the BodyMarshaler of cc, or a gob one if cc doesn't implement it
+func MarshalerOf(cc Codec) BodyMarshaler {
+	if m, ok := cc.(BodyMarshaler); ok {
+		return m
+	}
+	return gobMarshaler{}
+}
+
+func (c *CompressCodec) MarshalBody(body interface{}) ([]byte, error) {
+	return c.marshaler.MarshalBody(body)
+}
+
+func (c *CompressCodec) UnmarshalBody(data []byte, body interface{}) error {
+	return c.marshaler.UnmarshalBody(data, body)
+}
+
+func (c *CompressCodec) ReadHeader(header *Header) error {
+	if err := c.Codec.ReadHeader(header); err != nil {
+		return err
+	}
+	c.compress = header.Compress
+	return nil
+}
+
+func (c *CompressCodec) ReadBody(body interface{}) error {
+	typ := c.compress
+	c.compress = NoCompress
+	if typ == NoCompress {
+		return c.Codec.ReadBody(body)
+	}
+
+	var data []byte
+	if err := c.Codec.ReadBody(&data); err != nil || body == nil {
+		return err
+	}
+	comp, ok := compressors[typ]
+	if !ok {
+		return fmt.Errorf("rpc codec: unsupported compression %q", typ)
+	}
+	r, err := comp.newReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// read a byte more than allowed to tell a body of exactly maxSize from a larger one
+	if data, err = io.ReadAll(io.LimitReader(r, int64(c.maxSize)+1)); err != nil {
+		return err
+	}
+	if len(data) > c.maxSize {
+		return fmt.Errorf("%w: exceeds %d bytes", ErrBodyTooLarge, c.maxSize)
+	}
+	return c.marshaler.UnmarshalBody(data, body)
+}
+
+func (c *CompressCodec) Write(header *Header, body interface{}) error {
+	h, b, err := c.compressBody(header, body)
+	if err != nil {
+		return err
+	}
+	return c.Codec.Write(h, b)
+}
+
+func (c *CompressCodec) WriteBatch(headers []*Header, bodies []interface{}) error {
+	hs, bs := make([]*Header, len(headers)), make([]interface{}, len(bodies))
+	for i := range headers {
+		var err error
+		if hs[i], bs[i], err = c.compressBody(headers[i], bodies[i]); err != nil {
+			// still write the messages before the failing one, as the wrapped codec would
+			if err := WriteBatch(c.Codec, hs[:i], bs[:i]); err != nil {
+				return err
+			}
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return WriteBatch(c.Codec, hs, bs)
+}
+
+// compressBody returns the header and body to be written by the wrapped codec
+func (c *CompressCodec) compressBody(header *Header, body interface{}) (*Header, interface{}, error) {
+	comp, ok := compressors[c.typ]
+	if !ok {
+		return header, body, nil
+	}
+	data, err := c.marshaler.MarshalBody(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := *header
+	if len(data) < c.threshold {
+		h.Compress = NoCompress
+		if _, ok := c.Codec.(rawWriter); ok {
+			return &h, rawBody(data), nil
+		}
+		return &h, body, nil
+	}
+
+	var b bytes.Buffer
+	w, err := comp.newWriter(&b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, nil, err
+	}
+	h.Compress = c.typ
+	return &h, b.Bytes(), nil
+}
+
+// rawBody is a body already encoded by the BodyMarshaler of the codec writing it
+type rawBody []byte
+
+// rawWriter is implemented by codecs whose body encoding is the output of their BodyMarshaler,
+// they write a rawBody as is. Bodies written to other codecs are encoded again.
+type rawWriter interface {
+	writesRawBody()
+}
+
+type gobMarshaler struct{}
+
+func (gobMarshaler) MarshalBody(body interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(body)
+	return b.Bytes(), err
+}
+
+func (gobMarshaler) UnmarshalBody(data []byte, body interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(body)
+}
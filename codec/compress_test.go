@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCompressCodec(t *testing.T) {
+	c1, c2 := net.Pipe()
+	w := NewCompressCodec(NewGobCodec(c1), GzipCompress, 0)
+	r := NewCompressCodec(NewGobCodec(c2), NoCompress, 0)
+	defer w.Close()
+	defer r.Close()
+
+	big := make([]int, DefaultCompressThreshold)
+	go func() {
+		_ = w.Write(&Header{Seq: 1}, big)
+		_ = w.Write(&Header{Seq: 2}, []int{1, 2, 3})
+	}()
+
+	var h Header
+	var body []int
+	if err := r.ReadHeader(&h); err != nil || h.Compress != GzipCompress {
+		t.Fatalf("expect gzip compressed body, got %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&body); err != nil || !reflect.DeepEqual(body, big) {
+		t.Fatalf("read compressed body fail: %v", err)
+	}
+
+	h, body = Header{}, nil
+	if err := r.ReadHeader(&h); err != nil || h.Compress != NoCompress {
+		t.Fatalf("expect body under threshold uncompressed, got %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&body); err != nil || !reflect.DeepEqual(body, []int{1, 2, 3}) {
+		t.Fatalf("read uncompressed body fail: %v, %v", body, err)
+	}
+}
+
+func TestCompressCodecBomb(t *testing.T) {
+	c1, c2 := net.Pipe()
+	w := NewCompressCodec(NewGobCodec(c1), GzipCompress, 0)
+	r := NewCompressCodecSize(NewGobCodec(c2), NoCompress, 0, 1<<16)
+	defer w.Close()
+	defer r.Close()
+
+	// a megabyte of zeros compresses to about a kilobyte
+	go func() { _ = w.Write(&Header{Seq: 1}, make([]byte, 1<<20)) }()
+
+	var h Header
+	var body []byte
+	if err := r.ReadHeader(&h); err != nil || h.Compress != GzipCompress {
+		t.Fatalf("expect gzip compressed body, got %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&body); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expect ErrBodyTooLarge, got %v", err)
+	}
+}
+
+func TestCompressCodecRawBody(t *testing.T) {
+	for _, f := range []NewCodecFunc{NewJsonCodec, NewMsgpackCodec, NewFrameCodec} {
+		c1, c2 := net.Pipe()
+		w := NewCompressCodec(f(c1), GzipCompress, 0)
+		r := NewCompressCodec(f(c2), NoCompress, 0)
+		inner := r.(*CompressCodec).Codec
+
+		// bodies under the threshold are written as marshalled to measure them
+		_, b, err := w.(*CompressCodec).compressBody(&Header{Seq: 1}, []int{1, 2, 3})
+		if _, ok := b.(rawBody); !ok || err != nil {
+			t.Fatalf("%T: expect a raw body, got %T, %v", inner, b, err)
+		}
+		go func() { _ = w.Write(&Header{Seq: 1}, []int{1, 2, 3}) }()
+
+		var h Header
+		var body []int
+		if err := r.ReadHeader(&h); err != nil || h.Seq != 1 {
+			t.Fatalf("%T: read header fail: %+v, %v", inner, h, err)
+		}
+		if err := r.ReadBody(&body); err != nil || !reflect.DeepEqual(body, []int{1, 2, 3}) {
+			t.Fatalf("%T: read raw body fail: %v, %v", inner, body, err)
+		}
+		w.Close()
+		r.Close()
+	}
+}
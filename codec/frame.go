@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const FrameType Type = "application/x-nami-frame"
+
+// DefaultMaxFrameSize is the max size of a single header or body frame of FrameCodec
+const DefaultMaxFrameSize = 4 << 20
+
+const frameLenSize = 4
+
+var ErrFrameTooLarge = errors.New("rpc codec: frame too large")
+
+// FrameCodec writes every header and body as a separate frame, prefixed with
+// its length as a 4 bytes big endian integer. Each frame is a self-contained gob
+// value, so a bad or unwanted frame can be skipped without desynchronizing the stream.
+type FrameCodec struct {
+	conn    io.ReadWriteCloser
+	r       *bufio.Reader
+	buf     *bufio.Writer
+	maxSize int
+	lenBuf  [frameLenSize]byte
+}
+
+func NewFrameCodec(conn io.ReadWriteCloser) Codec {
+	return NewFrameCodecSize(DefaultMaxFrameSize)(conn)
+}
+
+// NewFrameCodecSize returns a NewCodecFunc making FrameCodec which refuses frames larger than maxSize
+func NewFrameCodecSize(maxSize int) NewCodecFunc {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return func(conn io.ReadWriteCloser) Codec {
+		return &FrameCodec{
+			conn:    conn,
+			r:       bufio.NewReader(conn),
+			buf:     bufio.NewWriter(conn),
+			maxSize: maxSize,
+		}
+	}
+}
+
+// readFrame returns the next frame, frames larger than maxSize are skipped without being allocated
+func (f *FrameCodec) readFrame() ([]byte, error) {
+	if _, err := io.ReadFull(f.r, f.lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(f.lenBuf[:])
+	if uint64(n) > uint64(f.maxSize) {
+		if _, err := io.CopyN(io.Discard, f.r, int64(n)); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d", ErrFrameTooLarge, n, f.maxSize)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(f.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (f *FrameCodec) ReadHeader(header *Header) error {
+	frame, err := f.readFrame()
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(frame)).Decode(header)
+}
+
+func (f *FrameCodec) ReadBody(body interface{}) error {
+	frame, err := f.readFrame()
+	if err != nil || body == nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(frame)).Decode(body)
+}
+
+func (f *FrameCodec) encodeFrame(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(make([]byte, frameLenSize))
+	if raw, ok := v.(rawBody); ok {
+		b.Write(raw)
+	} else if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	frame := b.Bytes()
+	n := len(frame) - frameLenSize
+	if n > f.maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d", ErrFrameTooLarge, n, f.maxSize)
+	}
+	binary.BigEndian.PutUint32(frame, uint32(n))
+	return frame, nil
+}
+
+func (f *FrameCodec) Write(header *Header, body interface{}) error {
+	defer f.flush()
+	return f.write(header, body)
+}
+
+func (f *FrameCodec) WriteBatch(headers []*Header, bodies []interface{}) error {
+	defer f.flush()
+	for i := range headers {
+		if err := f.write(headers[i], bodies[i]); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+func (f *FrameCodec) flush() {
+	if err := f.buf.Flush(); err != nil {
+		f.Close()
+	}
+}
+
+func (f *FrameCodec) write(header *Header, body interface{}) error {
+	// encode both frames first, so nothing is written if either one fails
+	h, err := f.encodeFrame(header)
+	if err != nil {
+		return fmt.Errorf("rpc codec: frame error encoding header: %w", err)
+	}
+	b, err := f.encodeFrame(body)
+	if err != nil {
+		return fmt.Errorf("rpc codec: frame error encoding body: %w", err)
+	}
+
+	if _, err := f.buf.Write(h); err != nil {
+		return err
+	}
+	_, err = f.buf.Write(b)
+	return err
+}
+
+// writesRawBody tells CompressCodec a frame is what its gob BodyMarshaler encodes
+func (f *FrameCodec) writesRawBody() {}
+
+func (f *FrameCodec) Close() error {
+	return f.conn.Close()
+}
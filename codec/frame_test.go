@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFrameCodecMaxSize(t *testing.T) {
+	c1, c2 := net.Pipe()
+	w := NewFrameCodecSize(1 << 20)(c1)
+	r := NewFrameCodecSize(256)(c2)
+	defer w.Close()
+	defer r.Close()
+
+	go func() {
+		_ = w.Write(&Header{ServiceMethod: "Foo.Big", Seq: 1}, strings.Repeat("x", 1024))
+		_ = w.Write(&Header{ServiceMethod: "Foo.Small", Seq: 2}, "ok")
+	}()
+
+	var h Header
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("read header: %+v, %v", h, err)
+	}
+	var body string
+	if err := r.ReadBody(&body); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+
+	// the oversized frame was skipped, the stream is still in sync
+	if err := r.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Small" || h.Seq != 2 {
+		t.Fatalf("read header after oversized frame: %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&body); err != nil || body != "ok" {
+		t.Fatalf("read body after oversized frame: %q, %v", body, err)
+	}
+
+	if err := r.Write(&Header{Seq: 3}, strings.Repeat("x", 1024)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expect ErrFrameTooLarge on write, got %v", err)
+	}
+}
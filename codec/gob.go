@@ -2,25 +2,29 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/gob"
 	"fmt"
 	"io"
 )
 
 type GobCodec struct {
-	conn io.ReadWriteCloser
-	buf  *bufio.Writer
-	dec  *gob.Decoder
-	enc  *gob.Encoder
+	conn      io.ReadWriteCloser
+	buf       *bufio.Writer
+	dec       *gob.Decoder
+	enc       *gob.Encoder
+	out       *bytes.Buffer // output of enc, kept until a whole message is encoded
+	typesSent bool          // the types of Header are sent
 }
 
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
+	out := new(bytes.Buffer)
 	return &GobCodec{
 		conn: conn,
-		buf:  buf,
+		buf:  bufio.NewWriter(conn),
 		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
+		enc:  gob.NewEncoder(out),
+		out:  out,
 	}
 }
 
@@ -33,24 +37,61 @@ func (g *GobCodec) ReadBody(body interface{}) error {
 }
 
 func (g *GobCodec) Write(header *Header, body interface{}) error {
-	defer func() {
-		err := g.buf.Flush()
-		if err != nil {
-			g.Close()
+	defer g.flush()
+	return g.write(header, body)
+}
+
+func (g *GobCodec) WriteBatch(headers []*Header, bodies []interface{}) error {
+	defer g.flush()
+	for i := range headers {
+		if err := g.write(headers[i], bodies[i]); err != nil {
+			return &BatchError{Index: i, Err: err}
 		}
-	}()
+	}
+	return nil
+}
 
-	if err := g.enc.Encode(header); err != nil {
-		fmt.Println("rpc codec: gob error encoding header: ", err)
+func (g *GobCodec) flush() {
+	if err := g.buf.Flush(); err != nil {
+		g.Close()
+	}
+}
+
+// write encodes the body before the header, so a body failing to encode leaves nothing
+// but the type definitions already sent by enc to be written. The types of the first
+// header must precede any body though, so the first body is checked beforehand.
+func (g *GobCodec) write(header *Header, body interface{}) error {
+	g.out.Reset()
+	if !g.typesSent {
+		if err := gob.NewEncoder(io.Discard).Encode(body); err != nil {
+			return fmt.Errorf("rpc codec: gob error encoding body: %w", err)
+		}
+		if err := g.enc.Encode(header); err != nil {
+			return fmt.Errorf("rpc codec: gob error encoding header: %w", err)
+		}
+		g.typesSent = true
+		if err := g.enc.Encode(body); err != nil {
+			return fmt.Errorf("rpc codec: gob error encoding body: %w", err)
+		}
+		_, err := g.buf.Write(g.out.Bytes())
 		return err
 	}
 
 	if err := g.enc.Encode(body); err != nil {
-		fmt.Println("rpc codec: gob error encoding body: ", err)
+		g.buf.Write(g.out.Bytes())
+		return fmt.Errorf("rpc codec: gob error encoding body: %w", err)
+	}
+	n := g.out.Len()
+	if err := g.enc.Encode(header); err != nil {
+		// enc takes the types of the body as sent, the stream can't go on without them
+		g.Close()
+		return fmt.Errorf("rpc codec: gob error encoding header: %w", err)
+	}
+	if _, err := g.buf.Write(g.out.Bytes()[n:]); err != nil {
 		return err
 	}
-
-	return nil
+	_, err := g.buf.Write(g.out.Bytes()[:n])
+	return err
 }
 
 func (g *GobCodec) Close() error {
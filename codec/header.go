@@ -1,7 +1,29 @@
 package codec
 
+import "time"
+
+// Kind tells what a message is
+type Kind uint8
+
+const (
+	KindCall      Kind = iota // request or response of a call
+	KindGoAway                // the server is shutting down, no more requests should be sent
+	KindCancel                // the client gave up the request Seq, the server stops handling it without reply
+	KindStream                // the client opens a stream calling a streaming method
+	KindStreamMsg             // a message of the stream Seq, the client sends it marshaled as []byte
+	KindStreamEnd             // end of the stream Seq, with the final error if any, or client's half-close
+	KindStreamAck             // grants the sender of the stream Seq credits to send more messages
+	KindNotify                // a one-way call, the server sends no response
+)
+
 type Header struct {
 	ServiceMethod string
 	Seq           uint64
 	Error         string
+	Code          uint32            // status code of Error
+	Details       map[string]string // details of Error
+	Compress      CompressType      // compression of the body, NoCompress if it's sent as is
+	Kind          Kind
+	Timeout       time.Duration     // time left to handle the request, 0 if there is no deadline
+	Metadata      map[string]string // metadata of the request, or trailers of the response
 }
@@ -0,0 +1,91 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JsonCodec encodes each header and body as a newline separated JSON value,
+// so it can be spoken by non-Go tooling (or by hand over netcat).
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *json.Decoder
+}
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	return &JsonCodec{
+		conn: conn,
+		buf:  bufio.NewWriter(conn),
+		dec:  json.NewDecoder(conn),
+	}
+}
+
+func (j *JsonCodec) ReadHeader(header *Header) error {
+	return j.dec.Decode(header)
+}
+
+func (j *JsonCodec) ReadBody(body interface{}) error {
+	// json refuses to decode into nil, consume the value to keep the stream in sync
+	if body == nil {
+		var discard json.RawMessage
+		return j.dec.Decode(&discard)
+	}
+	return j.dec.Decode(body)
+}
+
+func (j *JsonCodec) Write(header *Header, body interface{}) error {
+	defer j.flush()
+	return j.write(header, body)
+}
+
+func (j *JsonCodec) WriteBatch(headers []*Header, bodies []interface{}) error {
+	defer j.flush()
+	for i := range headers {
+		if err := j.write(headers[i], bodies[i]); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+func (j *JsonCodec) flush() {
+	if err := j.buf.Flush(); err != nil {
+		j.Close()
+	}
+}
+
+func (j *JsonCodec) write(header *Header, body interface{}) error {
+	// encode both values first, so nothing is written if either one fails
+	h, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("rpc codec: json error encoding header: %w", err)
+	}
+	b, ok := body.(rawBody)
+	if !ok {
+		if b, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("rpc codec: json error encoding body: %w", err)
+		}
+	}
+
+	j.buf.Write(h)
+	j.buf.WriteByte('\n')
+	j.buf.Write(b)
+	return j.buf.WriteByte('\n')
+}
+
+func (j *JsonCodec) Close() error {
+	return j.conn.Close()
+}
+
+func (j *JsonCodec) writesRawBody() {}
+
+func (j *JsonCodec) MarshalBody(body interface{}) ([]byte, error) {
+	return json.Marshal(body)
+}
+
+func (j *JsonCodec) UnmarshalBody(data []byte, body interface{}) error {
+	return json.Unmarshal(data, body)
+}
@@ -0,0 +1,859 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const MsgpackType Type = "application/msgpack"
+
+// DefaultMaxMsgpackSize is the max size of a single header or body of MsgpackCodec
+const DefaultMaxMsgpackSize = 4 << 20
+
+// maxMsgpackDepth is the max nesting of arrays and maps, so a value can't overflow the stack
+const maxMsgpackDepth = 10000
+
+var ErrMsgpackTooLarge = errors.New("rpc codec: msgpack value too large")
+
+// MsgpackCodec encodes headers and bodies as MessagePack values, one after another.
+// Structs are encoded as maps keyed by field name, or by the `msgpack` tag if any.
+// Unexported fields are skipped as encoding/json does, structs having no exported
+// field but unexported ones, such as time.Time, can't be encoded.
+type MsgpackCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *msgpackDecoder
+}
+
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	return NewMsgpackCodecSize(DefaultMaxMsgpackSize)(conn)
+}
+
+// NewMsgpackCodecSize returns a NewCodecFunc making MsgpackCodec which refuses headers and
+// bodies larger than maxSize. Lengths read are checked against it before anything is allocated.
+func NewMsgpackCodecSize(maxSize int) NewCodecFunc {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMsgpackSize
+	}
+	return func(conn io.ReadWriteCloser) Codec {
+		return &MsgpackCodec{
+			conn: conn,
+			buf:  bufio.NewWriter(conn),
+			dec:  &msgpackDecoder{r: bufio.NewReader(conn), maxSize: maxSize},
+		}
+	}
+}
+
+func (m *MsgpackCodec) ReadHeader(header *Header) error {
+	return m.dec.decode(header)
+}
+
+func (m *MsgpackCodec) ReadBody(body interface{}) error {
+	return m.dec.decode(body)
+}
+
+func (m *MsgpackCodec) Write(header *Header, body interface{}) error {
+	defer m.flush()
+	return m.write(header, body)
+}
+
+func (m *MsgpackCodec) WriteBatch(headers []*Header, bodies []interface{}) error {
+	defer m.flush()
+	for i := range headers {
+		if err := m.write(headers[i], bodies[i]); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+func (m *MsgpackCodec) flush() {
+	if err := m.buf.Flush(); err != nil {
+		m.Close()
+	}
+}
+
+func (m *MsgpackCodec) write(header *Header, body interface{}) error {
+	b, err := appendMsgpack(nil, reflect.ValueOf(header))
+	if err != nil {
+		return fmt.Errorf("rpc codec: msgpack error encoding header: %w", err)
+	}
+	if raw, ok := body.(rawBody); ok {
+		b = append(b, raw...)
+	} else if b, err = appendMsgpack(b, reflect.ValueOf(body)); err != nil {
+		return fmt.Errorf("rpc codec: msgpack error encoding body: %w", err)
+	}
+	_, err = m.buf.Write(b)
+	return err
+}
+
+func (m *MsgpackCodec) Close() error {
+	return m.conn.Close()
+}
+
+func (m *MsgpackCodec) writesRawBody() {}
+
+func (m *MsgpackCodec) MarshalBody(body interface{}) ([]byte, error) {
+	return appendMsgpack(nil, reflect.ValueOf(body))
+}
+
+func (m *MsgpackCodec) UnmarshalBody(data []byte, body interface{}) error {
+	dec := &msgpackDecoder{r: bufio.NewReader(bytes.NewReader(data)), maxSize: len(data)}
+	return dec.decode(body)
+}
+
+const (
+	mpNil      = 0xc0
+	mpFalse    = 0xc2
+	mpTrue     = 0xc3
+	mpBin8     = 0xc4
+	mpBin16    = 0xc5
+	mpBin32    = 0xc6
+	mpExt8     = 0xc7
+	mpExt16    = 0xc8
+	mpExt32    = 0xc9
+	mpFloat32  = 0xca
+	mpFloat64  = 0xcb
+	mpUint8    = 0xcc
+	mpUint16   = 0xcd
+	mpUint32   = 0xce
+	mpUint64   = 0xcf
+	mpInt8     = 0xd0
+	mpInt16    = 0xd1
+	mpInt32    = 0xd2
+	mpInt64    = 0xd3
+	mpFixExt1  = 0xd4
+	mpFixExt16 = 0xd8
+	mpStr8     = 0xd9
+	mpStr16    = 0xda
+	mpStr32    = 0xdb
+	mpArray16  = 0xdc
+	mpArray32  = 0xdd
+	mpMap16    = 0xde
+	mpMap32    = 0xdf
+)
+
+var errMsgpackType = errors.New("rpc codec: msgpack type mismatch")
+
+// msgpackField is an encoded field of a struct
+type msgpackField struct {
+	name  string
+	index int
+}
+
+var msgpackFields sync.Map // map[reflect.Type][]msgpackField
+
+func structFields(t reflect.Type) []msgpackField {
+	if fields, ok := msgpackFields.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("msgpack"); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, msgpackField{name: name, index: i})
+	}
+	msgpackFields.Store(t, fields)
+	return fields
+}
+
+// encodableFields returns the fields of t, it fails if t has unexported fields only,
+// which would be encoded as an empty map silently
+func encodableFields(t reflect.Type) ([]msgpackField, error) {
+	fields := structFields(t)
+	if len(fields) == 0 && t.NumField() > 0 {
+		return nil, fmt.Errorf("rpc codec: msgpack can't encode type %s, it has no exported field", t)
+	}
+	return fields, nil
+}
+
+func appendUint16(b []byte, u uint16) []byte {
+	return append(b, byte(u>>8), byte(u))
+}
+
+func appendUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(u>>32)), uint32(u))
+}
+
+func appendUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, mpUint8, byte(u))
+	case u <= math.MaxUint16:
+		return appendUint16(append(b, mpUint16), uint16(u))
+	case u <= math.MaxUint32:
+		return appendUint32(append(b, mpUint32), uint32(u))
+	default:
+		return appendUint64(append(b, mpUint64), u)
+	}
+}
+
+func appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, mpInt8, byte(i))
+	case i >= math.MinInt16:
+		return appendUint16(append(b, mpInt16), uint16(i))
+	case i >= math.MinInt32:
+		return appendUint32(append(b, mpInt32), uint32(i))
+	default:
+		return appendUint64(append(b, mpInt64), uint64(i))
+	}
+}
+
+// appendLen appends the header of a str, bin, array or map of n elements
+func appendLen(b []byte, n int, fix, fixMax byte, c8, c16, c32 byte) []byte {
+	switch {
+	case fixMax > 0 && n <= int(fixMax):
+		return append(b, fix|byte(n))
+	case c8 > 0 && n <= math.MaxUint8:
+		return append(b, c8, byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, c16), uint16(n))
+	default:
+		return appendUint32(append(b, c32), uint32(n))
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendLen(b, len(s), 0xa0, 31, mpStr8, mpStr16, mpStr32)
+	return append(b, s...)
+}
+
+func appendMsgpack(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, mpNil), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		return appendMsgpack(b, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, mpTrue), nil
+		}
+		return append(b, mpFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(b, v.Uint()), nil
+	case reflect.Float32:
+		return appendUint32(append(b, mpFloat32), math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return appendUint64(append(b, mpFloat64), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendString(b, v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b = appendLen(b, v.Len(), 0, 0, mpBin8, mpBin16, mpBin32)
+			if v.Kind() == reflect.Slice {
+				return append(b, v.Bytes()...), nil
+			}
+			for i := 0; i < v.Len(); i++ {
+				b = append(b, byte(v.Index(i).Uint()))
+			}
+			return b, nil
+		}
+		b = appendLen(b, v.Len(), 0x90, 15, 0, mpArray16, mpArray32)
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendMsgpack(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		b = appendLen(b, v.Len(), 0x80, 15, 0, mpMap16, mpMap32)
+		var err error
+		iter := v.MapRange()
+		for iter.Next() {
+			if b, err = appendMsgpack(b, iter.Key()); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgpack(b, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields, err := encodableFields(v.Type())
+		if err != nil {
+			return nil, err
+		}
+		b = appendLen(b, len(fields), 0x80, 15, 0, mpMap16, mpMap32)
+		for _, f := range fields {
+			b = appendString(b, f.name)
+			if b, err = appendMsgpack(b, v.Field(f.index)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("rpc codec: msgpack can't encode type %s", v.Type())
+	}
+}
+
+type msgpackDecoder struct {
+	r       *bufio.Reader
+	maxSize int // of a value
+	budget  int // bytes left to the value being decoded
+	depth   int // nesting of the value being decoded
+}
+
+// decode reads the next value into v, which must be a pointer, or nil to skip the value
+func (d *msgpackDecoder) decode(v interface{}) error {
+	d.budget, d.depth = d.maxSize, 0
+	if v == nil {
+		_, err := d.decodeAny()
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("rpc codec: msgpack decode requires a non-nil pointer, got %T", v)
+	}
+	return d.decodeValue(rv.Elem())
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	if d.budget <= 0 {
+		return 0, fmt.Errorf("%w: exceeds %d bytes", ErrMsgpackTooLarge, d.maxSize)
+	}
+	c, err := d.r.ReadByte()
+	if err == nil {
+		d.budget--
+	}
+	return c, err
+}
+
+func (d *msgpackDecoder) unreadByte() error {
+	err := d.r.UnreadByte()
+	if err == nil {
+		d.budget++
+	}
+	return err
+}
+
+// reserve checks that n elements of at least size bytes each fit in the rest of the value
+func (d *msgpackDecoder) reserve(n, size int) error {
+	if n < 0 || n > d.budget/size {
+		return fmt.Errorf("%w: %d elements exceed %d bytes", ErrMsgpackTooLarge, n, d.maxSize)
+	}
+	return nil
+}
+
+// nest enters an array or map, the returned func leaves it
+func (d *msgpackDecoder) nest() (func(), error) {
+	if d.depth >= maxMsgpackDepth {
+		return nil, fmt.Errorf("%w: nested deeper than %d", ErrMsgpackTooLarge, maxMsgpackDepth)
+	}
+	d.depth++
+	return func() { d.depth-- }, nil
+}
+
+func (d *msgpackDecoder) readN(n int) ([]byte, error) {
+	if err := d.reserve(n, 1); err != nil {
+		return nil, err
+	}
+	d.budget -= n
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.readN(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// readLen reads the length of a str, bin, array or map whose header is c
+func (d *msgpackDecoder) readLen(c byte) (int, error) {
+	var size int
+	switch {
+	case c >= 0xa0 && c <= 0xbf:
+		return int(c & 0x1f), nil
+	case c >= 0x90 && c <= 0x9f, c >= 0x80 && c <= 0x8f:
+		return int(c & 0x0f), nil
+	case c == mpStr8, c == mpBin8:
+		size = 1
+	case c == mpStr16, c == mpBin16, c == mpArray16, c == mpMap16:
+		size = 2
+	default:
+		size = 4
+	}
+	n, err := d.readUint(size)
+	return int(n), err
+}
+
+func isStr(c byte) bool {
+	return c >= 0xa0 && c <= 0xbf || c == mpStr8 || c == mpStr16 || c == mpStr32
+}
+
+func isBin(c byte) bool {
+	return c == mpBin8 || c == mpBin16 || c == mpBin32
+}
+
+func isArray(c byte) bool {
+	return c >= 0x90 && c <= 0x9f || c == mpArray16 || c == mpArray32
+}
+
+func isMap(c byte) bool {
+	return c >= 0x80 && c <= 0x8f || c == mpMap16 || c == mpMap32
+}
+
+// readNumber reads an integer or float whose header is c
+func (d *msgpackDecoder) readNumber(c byte) (interface{}, error) {
+	switch {
+	case c <= 0x7f:
+		return uint64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	}
+	switch c {
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		return d.readUint(1 << (c - mpUint8))
+	case mpInt8, mpInt16, mpInt32, mpInt64:
+		size := 1 << (c - mpInt8)
+		u, err := d.readUint(size)
+		switch size {
+		case 1:
+			return int64(int8(u)), err
+		case 2:
+			return int64(int16(u)), err
+		case 4:
+			return int64(int32(u)), err
+		default:
+			return int64(u), err
+		}
+	case mpFloat32:
+		u, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case mpFloat64:
+		u, err := d.readUint(8)
+		return math.Float64frombits(u), err
+	}
+	return nil, errMsgpackType
+}
+
+// decodeAny reads the next value as nil, bool, int64, uint64, float64, string,
+// []byte, []interface{} or map[string]interface{}
+func (d *msgpackDecoder) decodeAny() (interface{}, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == mpNil:
+		return nil, nil
+	case c == mpFalse, c == mpTrue:
+		return c == mpTrue, nil
+	case isStr(c), isBin(c):
+		n, err := d.readLen(c)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readN(n)
+		if err != nil || isBin(c) {
+			return b, err
+		}
+		return string(b), nil
+	case isArray(c):
+		n, err := d.readLen(c)
+		if err == nil {
+			err = d.reserve(n, 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		leave, err := d.nest()
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		arr := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			e, err := d.decodeAny()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, e)
+		}
+		return arr, nil
+	case isMap(c):
+		n, err := d.readLen(c)
+		if err == nil {
+			err = d.reserve(n, 2)
+		}
+		if err != nil {
+			return nil, err
+		}
+		leave, err := d.nest()
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := d.decodeAny()
+			if err != nil {
+				return nil, err
+			}
+			e, err := d.decodeAny()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = e
+		}
+		return m, nil
+	case c >= mpFixExt1 && c <= mpFixExt16, c == mpExt8, c == mpExt16, c == mpExt32:
+		// ext values are not supported, skip the type byte and keep the raw data
+		n := 1 << (c - mpFixExt1)
+		if c < mpFixExt1 {
+			size := 1 << (c - mpExt8)
+			u, err := d.readUint(size)
+			if err != nil {
+				return nil, err
+			}
+			n = int(u)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%w: ext of %d bytes", ErrMsgpackTooLarge, uint(n))
+		}
+		b, err := d.readN(n + 1)
+		if err != nil {
+			return nil, err
+		}
+		return b[1:], nil
+	default:
+		return d.readNumber(c)
+	}
+}
+
+func (d *msgpackDecoder) decodeValue(v reflect.Value) error {
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		e, err := d.decodeAny()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(e))
+		}
+		return nil
+	}
+
+	c, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if c == mpNil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if c != mpFalse && c != mpTrue {
+			return d.mismatch(c, v)
+		}
+		v.SetBool(c == mpTrue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if c == mpFloat32 || c == mpFloat64 {
+			return d.mismatch(c, v)
+		}
+		n, err := d.readNumber(c)
+		if err != nil {
+			return d.mismatch(c, v)
+		}
+		var i int64
+		switch n := n.(type) {
+		case int64:
+			i = n
+		case uint64:
+			if n > math.MaxInt64 {
+				return fmt.Errorf("%w: %d overflows %s", errMsgpackType, n, v.Type())
+			}
+			i = int64(n)
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("%w: %d overflows %s", errMsgpackType, i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if c == mpFloat32 || c == mpFloat64 {
+			return d.mismatch(c, v)
+		}
+		n, err := d.readNumber(c)
+		if err != nil {
+			return d.mismatch(c, v)
+		}
+		var u uint64
+		switch n := n.(type) {
+		case uint64:
+			u = n
+		case int64:
+			if n < 0 {
+				return fmt.Errorf("%w: %d overflows %s", errMsgpackType, n, v.Type())
+			}
+			u = uint64(n)
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("%w: %d overflows %s", errMsgpackType, u, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, err := d.readNumber(c)
+		if err != nil {
+			return d.mismatch(c, v)
+		}
+		switch n := n.(type) {
+		case float64:
+			v.SetFloat(n)
+		case int64:
+			v.SetFloat(float64(n))
+		case uint64:
+			v.SetFloat(float64(n))
+		}
+	case reflect.String:
+		if !isStr(c) && !isBin(c) {
+			return d.mismatch(c, v)
+		}
+		b, err := d.readBytes(c)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (isBin(c) || isStr(c)) {
+			b, err := d.readBytes(c)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		if !isArray(c) {
+			return d.mismatch(c, v)
+		}
+		n, err := d.readLen(c)
+		if err == nil {
+			err = d.reserve(n, 1)
+		}
+		if err != nil {
+			return err
+		}
+		leave, err := d.nest()
+		if err != nil {
+			return err
+		}
+		defer leave()
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decodeValue(s.Index(i)); err != nil {
+				return d.skipRest(n-i-1, err)
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (isBin(c) || isStr(c)) {
+			b, err := d.readBytes(c)
+			if err != nil {
+				return err
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+		if !isArray(c) {
+			return d.mismatch(c, v)
+		}
+		n, err := d.readLen(c)
+		if err == nil {
+			err = d.reserve(n, 1)
+		}
+		if err != nil {
+			return err
+		}
+		leave, err := d.nest()
+		if err != nil {
+			return err
+		}
+		defer leave()
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				if _, err := d.decodeAny(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeValue(v.Index(i)); err != nil {
+				return d.skipRest(n-i-1, err)
+			}
+		}
+	case reflect.Map:
+		if !isMap(c) {
+			return d.mismatch(c, v)
+		}
+		n, err := d.readLen(c)
+		if err == nil {
+			err = d.reserve(n, 2)
+		}
+		if err != nil {
+			return err
+		}
+		leave, err := d.nest()
+		if err != nil {
+			return err
+		}
+		defer leave()
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		}
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decodeValue(key); err != nil {
+				return d.skipRest(2*(n-i)-1, err)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decodeValue(elem); err != nil {
+				return d.skipRest(2*(n-i-1), err)
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		if !isMap(c) {
+			return d.mismatch(c, v)
+		}
+		n, err := d.readLen(c)
+		if err == nil {
+			err = d.reserve(n, 2)
+		}
+		if err != nil {
+			return err
+		}
+		leave, err := d.nest()
+		if err != nil {
+			return err
+		}
+		defer leave()
+		fields, err := encodableFields(v.Type())
+		if err != nil {
+			return d.skipRest(2*n, fmt.Errorf("%w: %s has no exported field", errMsgpackType, v.Type()))
+		}
+		for i := 0; i < n; i++ {
+			var name string
+			if err := d.decodeValue(reflect.ValueOf(&name).Elem()); err != nil {
+				return d.skipRest(2*(n-i)-1, err)
+			}
+			field := -1
+			for _, f := range fields {
+				if f.name == name || field < 0 && strings.EqualFold(f.name, name) {
+					field = f.index
+				}
+			}
+			if field < 0 {
+				// unknown field
+				if _, err := d.decodeAny(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeValue(v.Field(field)); err != nil {
+				return d.skipRest(2*(n-i-1), err)
+			}
+		}
+	default:
+		return d.mismatch(c, v)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) readBytes(c byte) ([]byte, error) {
+	n, err := d.readLen(c)
+	if err != nil {
+		return nil, err
+	}
+	return d.readN(n)
+}
+
+// mismatch skips the value whose header c was just read, so the stream stays in sync
+func (d *msgpackDecoder) mismatch(c byte, v reflect.Value) error {
+	if err := d.unreadByte(); err == nil {
+		if _, err := d.decodeAny(); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: can't decode 0x%02x into %s", errMsgpackType, c, v.Type())
+}
+
+// skipRest skips the n values left in the array or map whose element failed with err, so
+// decoding ends at the boundary of the enclosing value. A type mismatch leaves the failed
+// element read in full, any other error means the stream is broken anyway.
+func (d *msgpackDecoder) skipRest(n int, err error) error {
+	if !errors.Is(err, errMsgpackType) {
+		return err
+	}
+	for ; n > 0; n-- {
+		if _, err := d.decodeAny(); err != nil {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type msgpackInner struct {
+	Name  string
+	Tags  map[string]string
+	Score float64
+}
+
+type msgpackOuter struct {
+	Int     int
+	Neg     int64
+	Uint    uint32
+	Small   int8
+	Float   float32
+	Ok      bool
+	Bytes   []byte
+	Ints    []int
+	Inner   msgpackInner
+	Ptr     *msgpackInner
+	Nil     *msgpackInner
+	Any     interface{}
+	Renamed string `msgpack:"r"`
+	Skip    string `msgpack:"-"`
+	private int
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	in := msgpackOuter{
+		Int:     1 << 40,
+		Neg:     -70000,
+		Uint:    math.MaxUint32,
+		Small:   -5,
+		Float:   1.5,
+		Ok:      true,
+		Bytes:   []byte("raw"),
+		Ints:    []int{1, -1, 300},
+		Inner:   msgpackInner{Name: "inner", Tags: map[string]string{"k": "v"}, Score: -0.25},
+		Ptr:     &msgpackInner{Name: "ptr"},
+		Any:     "any",
+		Renamed: "renamed",
+		Skip:    "skip",
+	}
+
+	c1, c2 := net.Pipe()
+	w, r := NewMsgpackCodec(c1), NewMsgpackCodec(c2)
+	defer w.Close()
+	defer r.Close()
+	go func() {
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 42, Error: "none"}, &in)
+		_ = w.Write(&Header{Seq: 43}, in)
+	}()
+
+	var h Header
+	if err := r.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Sum" || h.Seq != 42 || h.Error != "none" {
+		t.Fatalf("read header: %+v, %v", h, err)
+	}
+	var out msgpackOuter
+	if err := r.ReadBody(&out); err != nil {
+		t.Fatal("read body: ", err)
+	}
+	expect := in
+	expect.Skip = ""
+	if !reflect.DeepEqual(expect, out) {
+		t.Fatalf("expect %+v, got %+v", expect, out)
+	}
+
+	// a skipped body leaves the stream in sync
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 43 {
+		t.Fatalf("read header: %+v, %v", h, err)
+	}
+	if err := r.ReadBody(nil); err != nil {
+		t.Fatal("skip body: ", err)
+	}
+}
+
+func TestMsgpackOversized(t *testing.T) {
+	var m MsgpackCodec
+	for name, data := range map[string][]byte{
+		"array32": {mpArray32, 0xff, 0xff, 0xff, 0xff},
+		"map32":   {mpMap32, 0xff, 0xff, 0xff, 0xff},
+		"bin32":   {mpBin32, 0xff, 0xff, 0xff, 0xff},
+		"str32":   {mpStr32, 0xff, 0xff, 0xff, 0xff},
+		"ext32":   {mpExt32, 0xff, 0xff, 0xff, 0xff, 1},
+		"nested":  bytes.Repeat([]byte{0x91}, 2*maxMsgpackDepth),
+	} {
+		// claimed lengths are checked before allocating, whatever the target
+		for _, body := range []interface{}{nil, new([]int), new(map[string]int), new([]byte), new(string)} {
+			m.dec = &msgpackDecoder{r: bufio.NewReader(bytes.NewReader(data)), maxSize: 1 << 10}
+			if name == "nested" {
+				m.dec.maxSize = len(data)
+			}
+			if err := m.ReadBody(body); err == nil {
+				t.Fatalf("%s into %T: expect an error", name, body)
+			}
+		}
+		if err := m.UnmarshalBody(data, new(interface{})); !errors.Is(err, ErrMsgpackTooLarge) {
+			t.Fatalf("%s: expect ErrMsgpackTooLarge, got %v", name, err)
+		}
+	}
+}
+
+func TestMsgpackUnexported(t *testing.T) {
+	var m MsgpackCodec
+	if _, err := m.MarshalBody(time.Now()); err == nil {
+		t.Fatal("expect a struct of unexported fields only to be refused")
+	}
+	if _, err := m.MarshalBody(struct{}{}); err != nil {
+		t.Fatalf("expect an empty struct to be encoded, got %v", err)
+	}
+}
+
+func FuzzMsgpackDecode(f *testing.F) {
+	var m MsgpackCodec
+	seed, _ := m.MarshalBody(msgpackOuter{Ints: []int{1, 2}, Inner: msgpackInner{Tags: map[string]string{"a": "b"}}})
+	f.Add(seed)
+	f.Add([]byte{mpArray32, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var out msgpackOuter
+		_ = m.UnmarshalBody(data, &out)
+		var v interface{}
+		_ = m.UnmarshalBody(data, &v)
+	})
+}
+
+func TestMsgpackMismatchInSync(t *testing.T) {
+	bodies := []interface{}{
+		[]interface{}{1, "x", 3},
+		map[string]interface{}{"a": "x", "b": 2},
+		map[string]interface{}{"Name": 1, "Score": 2.5, "Tags": map[string]string{"k": "v"}},
+	}
+	targets := []interface{}{new([]int), new(map[string]int), new(msgpackInner)}
+	conn := &countingConn{}
+	w := NewMsgpackCodec(conn)
+	for i, body := range bodies {
+		if err := w.Write(&Header{Seq: uint64(i)}, body); err != nil {
+			t.Fatalf("write %d fail: %v", i, err)
+		}
+	}
+	if err := w.Write(&Header{Seq: uint64(len(bodies))}, 5); err != nil {
+		t.Fatalf("write fail: %v", err)
+	}
+
+	r := NewMsgpackCodec(struct {
+		io.Reader
+		io.WriteCloser
+	}{&conn.Buffer, conn})
+	for i, target := range targets {
+		var h Header
+		if err := r.ReadHeader(&h); err != nil || h.Seq != uint64(i) {
+			t.Fatalf("expect header %d, got %+v, %v", i, h, err)
+		}
+		if err := r.ReadBody(target); !errors.Is(err, errMsgpackType) {
+			t.Fatalf("expect a type mismatch decoding %v into %T, got %v", bodies[i], target, err)
+		}
+	}
+	var h Header
+	var n int
+	if err := r.ReadHeader(&h); err != nil || h.Seq != uint64(len(bodies)) {
+		t.Fatalf("expect the stream to stay in sync, got %+v, %v", h, err)
+	}
+	if err := r.ReadBody(&n); err != nil || n != 5 {
+		t.Fatalf("expect body 5, got %d, %v", n, err)
+	}
+}
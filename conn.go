@@ -0,0 +1,133 @@
+package nami
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/xeasy/nami/codec"
+)
+
+// serverConn represents a connection served by Server
+type serverConn struct {
+	cc         codec.Codec
+	opt        *Option
+	remoteAddr string
+	peerCert   *x509.Certificate // verified client certificate of a TLS connection
+	principal  string            // authenticated by the server's Authenticator
+	ctx        context.Context   // cancelled when the connection is closed
+	cancel     context.CancelFunc
+	sending    sync.Mutex     // make sure a response is written completely
+	wg         sync.WaitGroup // wait for handling requests
+	limiter    *limiter       // the sem is made for, replaced with the server's Limits
+	sem        chan struct{}  // per-connection limit
+
+	mu       sync.Mutex // protect following
+	requests map[uint64]context.CancelFunc
+	streams  map[uint64]*serverStream
+	inFlight int
+	closed   bool
+}
+
+func newServerConn(cc codec.Codec, opt *Option, remoteAddr string) *serverConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &serverConn{
+		cc:         cc,
+		opt:        opt,
+		remoteAddr: remoteAddr,
+		ctx:        ctx,
+		cancel:     cancel,
+		requests:   make(map[uint64]context.CancelFunc),
+		streams:    make(map[uint64]*serverStream),
+	}
+}
+
+// begin tracks a new request and returns its context, cancelled on timeout, on the
+// client's cancellation or when the connection is closed. It fails if the connection is closed
+func (sc *serverConn) begin(seq uint64, timeout time.Duration) (context.Context, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return nil, false
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(sc.ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(sc.ctx)
+	}
+	sc.requests[seq] = cancel
+	sc.inFlight++
+	sc.wg.Add(1)
+	return ctx, true
+}
+
+func (sc *serverConn) end(seq uint64) {
+	sc.mu.Lock()
+	if cancel := sc.requests[seq]; cancel != nil {
+		cancel()
+		delete(sc.requests, seq)
+	}
+	sc.inFlight--
+	sc.mu.Unlock()
+	sc.wg.Done()
+}
+
+// cancelRequest cancels the context of the request seq, if it's still handling
+func (sc *serverConn) cancelRequest(seq uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if cancel := sc.requests[seq]; cancel != nil {
+		cancel()
+	}
+}
+
+func (sc *serverConn) trackStream(ss *serverStream, add bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if add {
+		sc.streams[ss.seq] = ss
+	} else {
+		delete(sc.streams, ss.seq)
+	}
+}
+
+func (sc *serverConn) stream(seq uint64) *serverStream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[seq]
+}
+
+// write sends a message to client
+func (sc *serverConn) write(h *codec.Header, body any) error {
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	return sc.cc.Write(h, body)
+}
+
+// closeIfIdle closes the connection if there isn't any request in flight
+func (sc *serverConn) closeIfIdle() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.inFlight > 0 {
+		return false
+	}
+	sc.closeLocked()
+	return true
+}
+
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.closeLocked()
+}
+
+func (sc *serverConn) closeLocked() {
+	if !sc.closed {
+		sc.closed = true
+		sc.cancel()
+		_ = sc.cc.Close()
+	}
+}
@@ -0,0 +1,74 @@
+package nami
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+)
+
+type ctxKey int
+
+const requestInfoKey ctxKey = iota
+
+// RequestInfo describes the request being handled, it's carried by the context passed to service methods
+type RequestInfo struct {
+	ServiceMethod string
+	Seq           uint64
+	RemoteAddr    string            // empty if the connection has no network address
+	Metadata      map[string]string // sent by client along with the request
+	// PeerCertificate is the client certificate verified by a mutual TLS connection, nil otherwise
+	PeerCertificate *x509.Certificate
+	// Principal is who the connection or request is authenticated as by the server's Authenticator
+	Principal string
+
+	mu      sync.Mutex // protect following
+	trailer map[string]string
+}
+
+// RequestInfoFromContext returns the RequestInfo of the request handled with ctx
+func RequestInfoFromContext(ctx context.Context) (*RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey).(*RequestInfo)
+	return info, ok
+}
+
+func withRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey, info)
+}
+
+// MetadataFromContext returns the metadata the client sent along with the request handled with ctx
+func MetadataFromContext(ctx context.Context) map[string]string {
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		return info.Metadata
+	}
+	return nil
+}
+
+// SetTrailer attaches a trailer to the response of the request handled with ctx.
+// It returns false if ctx doesn't belong to a request.
+func SetTrailer(ctx context.Context, key, value string) bool {
+	info, ok := RequestInfoFromContext(ctx)
+	if !ok {
+		return false
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.trailer == nil {
+		info.trailer = make(map[string]string)
+	}
+	info.trailer[key] = value
+	return true
+}
+
+// Trailer returns a copy of the trailers set by SetTrailer
+func (info *RequestInfo) Trailer() map[string]string {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.trailer == nil {
+		return nil
+	}
+	trailer := make(map[string]string, len(info.trailer))
+	for k, v := range info.trailer {
+		trailer[k] = v
+	}
+	return trailer
+}
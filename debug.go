@@ -2,23 +2,41 @@ package nami
 
 import (
 	"fmt"
+	"html/template"
 	"net/http"
-	"text/template"
 )
 
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Panics</th><th align=center>Notify Errors</th>
 		{{range $name, $mtype := .Method}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
+			<td align=left font=fixed>{{$name}}({{if $mtype.HasContext}}context.Context, {{end}}{{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$mtype.NumPanics}}</td>
+			<td align=center>{{$mtype.NumNotifyErrors}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	<hr>
+	Notify errors of unknown methods: {{.NumNotifyErrors}}
+	{{range .RateLimiters}}
+	<hr>
+	Rate limiter {{.Name}}: {{.Rate}}/s, burst {{.Burst}}, allowed {{.Allowed}}, rejected {{.Rejected}}
+	<hr>
+		<table>
+		<th align=center>Key</th><th align=center>Tokens</th>
+		{{range .Buckets}}
+			<tr>
+			<td align=left font=fixed>{{.Key}}</td>
+			<td align=center>{{printf "%.2f" .Tokens}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -37,16 +55,29 @@ type debugService struct {
 	Method map[string]*methodType
 }
 
+type debugData struct {
+	Services        []debugService
+	NumNotifyErrors uint64 // of the server, not counted by any method
+	RateLimiters    []RateLimiterState
+}
+
 func (ds debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	var services []debugService
+	data := debugData{NumNotifyErrors: ds.NumNotifyErrors()}
 	ds.serviceMap.Range(func(namei, svci any) bool {
 		svc := svci.(*service)
 		name := namei.(string)
-		services = append(services, debugService{Name: name, Method: svc.method})
+		data.Services = append(data.Services, debugService{Name: name, Method: svc.method})
 		return true
 	})
 
-	err := debug.Execute(w, services)
+	ds.mu.Lock()
+	rateLimiters := ds.rateLimiters
+	ds.mu.Unlock()
+	for _, rl := range rateLimiters {
+		data.RateLimiters = append(data.RateLimiters, rl.State())
+	}
+
+	err := debug.Execute(w, data)
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc service: Execute template error: ", err)
 	}
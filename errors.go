@@ -0,0 +1,143 @@
+package nami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xeasy/nami/codec"
+)
+
+// Code is the status code of an Error
+type Code uint32
+
+const (
+	CodeOK                Code = iota
+	CodeUnknown                // error returned by a service method without a code
+	CodeInvalidArgument        // the request is ill-formed or its body can't be read
+	CodeNotFound               // service or method not found
+	CodeDeadlineExceeded       // the request isn't handled in time
+	CodeCanceled               // the request is cancelled by the caller
+	CodeUnavailable            // the server is shutting down or the connection is broken
+	CodeInternal               // the server fails to handle the request
+	CodeResourceExhausted      // a limit is exceeded
+	CodeUnauthenticated        // the connection or request has no valid credentials
+	CodePermissionDenied       // the caller isn't allowed to call the method
+)
+
+var codeNames = [...]string{
+	CodeOK:                "OK",
+	CodeUnknown:           "Unknown",
+	CodeInvalidArgument:   "InvalidArgument",
+	CodeNotFound:          "NotFound",
+	CodeDeadlineExceeded:  "DeadlineExceeded",
+	CodeCanceled:          "Canceled",
+	CodeUnavailable:       "Unavailable",
+	CodeInternal:          "Internal",
+	CodeResourceExhausted: "ResourceExhausted",
+	CodeUnauthenticated:   "Unauthenticated",
+	CodePermissionDenied:  "PermissionDenied",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) && codeNames[c] != "" {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// Error is an error with a status code, it crosses the wire through codec.Header.
+// Service methods may return it to tell the client what went wrong.
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string
+
+	cause error // the local error this one is made from, not sent
+}
+
+// Well-known errors, use errors.Is to check the code of an error against them
+var (
+	ErrInvalidArgument   = &Error{Code: CodeInvalidArgument}
+	ErrNotFound          = &Error{Code: CodeNotFound}
+	ErrDeadlineExceeded  = &Error{Code: CodeDeadlineExceeded}
+	ErrCanceled          = &Error{Code: CodeCanceled}
+	ErrUnavailable       = &Error{Code: CodeUnavailable}
+	ErrInternal          = &Error{Code: CodeInternal}
+	ErrResourceExhausted = &Error{Code: CodeResourceExhausted}
+	ErrUnauthenticated   = &Error{Code: CodeUnauthenticated}
+)
+
+// ContextCode returns the code of a context error, CodeDeadlineExceeded or CodeCanceled
+func ContextCode(err error) Code {
+	if err == context.DeadlineExceeded {
+		return CodeDeadlineExceeded
+	}
+	return CodeCanceled
+}
+
+func NewError(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+func Errorf(code Code, format string, a ...any) *Error {
+	err := fmt.Errorf(format, a...)
+	return &Error{Code: code, Message: err.Error(), cause: errors.Unwrap(err)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is an *Error with the same code, and the same message if target has one
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+// WithDetail returns a copy of e with the detail added
+func (e *Error) WithDetail(key, value string) *Error {
+	err := *e
+	err.Details = make(map[string]string, len(e.Details)+1)
+	for k, v := range e.Details {
+		err.Details[k] = v
+	}
+	err.Details[key] = value
+	return &err
+}
+
+// toError converts err to an *Error, errors without code get CodeUnknown
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Code: CodeUnknown, Message: err.Error(), cause: err}
+}
+
+// setHeaderError writes err into h, with the code of the *Error it wraps and its whole message
+func setHeaderError(h *codec.Header, err error) {
+	e := toError(err)
+	h.Error = err.Error()
+	h.Code = uint32(e.Code)
+	h.Details = e.Details
+}
+
+// ErrorFromHeader returns the error carried by h, nil if there is none
+func ErrorFromHeader(h *codec.Header) error {
+	if h.Error == "" && h.Code == uint32(CodeOK) {
+		return nil
+	}
+	code := Code(h.Code)
+	if code == CodeOK {
+		code = CodeUnknown
+	}
+	return &Error{Code: code, Message: h.Error, Details: h.Details}
+}
@@ -0,0 +1,29 @@
+package nami
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xeasy/nami/codec"
+)
+
+func TestErrorHeader(t *testing.T) {
+	var h codec.Header
+	setHeaderError(&h, NewError(CodeNotFound, "no such service").WithDetail("service", "Foo"))
+	err := ErrorFromHeader(&h)
+	_assert(errors.Is(err, ErrNotFound), "expect not found error, got %v", err)
+	_assert(!errors.Is(err, ErrInternal), "not found error shouldn't match internal")
+	_assert(err.(*Error).Details["service"] == "Foo", "lost error details")
+
+	h = codec.Header{}
+	setHeaderError(&h, fmt.Errorf("wrapped: %w", NewError(CodeInvalidArgument, "bad")))
+	err = ErrorFromHeader(&h)
+	_assert(errors.Is(err, ErrInvalidArgument) && err.Error() == "wrapped: bad", "expect the wrapped invalid argument error, got %v", err)
+
+	h = codec.Header{Error: "100% plain"}
+	err = ErrorFromHeader(&h)
+	_assert(err.Error() == "100% plain" && errors.Is(err, &Error{Code: CodeUnknown}), "expect unknown error, got %v", err)
+
+	_assert(ErrorFromHeader(&codec.Header{}) == nil, "expect no error")
+}
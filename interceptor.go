@@ -0,0 +1,81 @@
+package nami
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	runtimedebug "runtime/debug"
+)
+
+// UnaryServerInfo describes the call being intercepted
+type UnaryServerInfo struct {
+	ServiceMethod string // e.g. "Foo.Sum"
+	Service       string
+	Method        string
+}
+
+// UnaryHandler invokes the service method with argv and replyv
+type UnaryHandler func(ctx context.Context, argv, replyv any) error
+
+// UnaryServerInterceptor intercepts the call of a service method. It may short-circuit
+// by returning an error without calling handler, the error is sent back to the client.
+type UnaryServerInterceptor func(ctx context.Context, info *UnaryServerInfo, argv, replyv any, handler UnaryHandler) error
+
+// Use appends interceptors to the chain, the first one is the outermost
+func (s *Server) Use(interceptors ...UnaryServerInterceptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interceptors = append(s.interceptors[:len(s.interceptors):len(s.interceptors)], interceptors...)
+}
+
+// invoke calls the method of req through the interceptor chain, a panic of an interceptor
+// is recovered and returned as an internal error like a panic of the method
+func (s *Server) invoke(ctx context.Context, req *request) (err error) {
+	s.mu.Lock()
+	interceptors, repanic := s.interceptors, s.repanic
+	s.mu.Unlock()
+
+	var repanicked bool // by the handler, once the panic of the method is logged
+	defer func() {
+		if r := recover(); r != nil {
+			if repanicked {
+				panic(r)
+			}
+			p := &panicError{value: r, stack: runtimedebug.Stack()}
+			s.log().Error("rpc server: interceptor panic", "seq", req.h.Seq, "method", req.h.ServiceMethod, "panic", p.value, "stack", string(p.stack))
+			if repanic {
+				panic(r)
+			}
+			err = Errorf(CodeInternal, "rpc server: %s: %w", req.h.ServiceMethod, p)
+		}
+	}()
+
+	handler := func(ctx context.Context, argv, replyv any) error {
+		err := req.svc.call(ctx, req.mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
+		var p *panicError
+		if errors.As(err, &p) {
+			s.log().Error("rpc server: method panic", "seq", req.h.Seq, "method", req.h.ServiceMethod, "panic", p.value, "stack", string(p.stack))
+			if repanic {
+				repanicked = true
+				panic(p.value)
+			}
+		}
+		return err
+	}
+	if len(interceptors) == 0 {
+		return handler(ctx, req.argv.Interface(), req.replyv.Interface())
+	}
+
+	info := &UnaryServerInfo{
+		ServiceMethod: req.h.ServiceMethod,
+		Service:       req.svc.name,
+		Method:        req.mtype.method.Name,
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, argv, replyv any) error {
+			return interceptor(ctx, info, argv, replyv, next)
+		}
+	}
+	return handler(ctx, req.argv.Interface(), req.replyv.Interface())
+}
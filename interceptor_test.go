@@ -0,0 +1,69 @@
+package nami
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xeasy/nami/codec"
+)
+
+func newTestRequest(s *Server, serviceMethod string, args Args) *request {
+	req := &request{h: &codec.Header{ServiceMethod: serviceMethod}}
+	req.svc, req.mtype, _ = s.findService(serviceMethod)
+	req.argv = req.mtype.newArgv()
+	req.replyv = req.mtype.newReplyv()
+	req.argv.Set(reflect.ValueOf(args))
+	return req
+}
+
+func TestServerInterceptors(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Regiest(&foo)
+
+	var order []string
+	s.Use(func(ctx context.Context, info *UnaryServerInfo, argv, replyv any, handler UnaryHandler) error {
+		order = append(order, "outer:"+info.ServiceMethod)
+		return handler(ctx, argv, replyv)
+	}, func(ctx context.Context, info *UnaryServerInfo, argv, replyv any, handler UnaryHandler) error {
+		order = append(order, "inner:"+info.Service+"/"+info.Method)
+		if argv.(Args).Num1 < 0 {
+			return errors.New("negative number")
+		}
+		return handler(ctx, argv, replyv)
+	})
+
+	req := newTestRequest(s, "Foo.Sum", Args{Num1: 1, Num2: 3})
+	err := s.invoke(context.Background(), req)
+	_assert(err == nil && *req.replyv.Interface().(*int) == 4, "fail to call Foo.Sum through interceptors: %v", err)
+	_assert(reflect.DeepEqual(order, []string{"outer:Foo.Sum", "inner:Foo/Sum"}), "wrong interceptor order %v", order)
+
+	req = newTestRequest(s, "Foo.Sum", Args{Num1: -1, Num2: 3})
+	err = s.invoke(context.Background(), req)
+	_assert(err != nil && err.Error() == "negative number", "expect short-circuit error, got %v", err)
+	_assert(req.mtype.NumCalls() == 1, "short-circuited call shouldn't reach Foo.Sum")
+}
+
+func TestServerInterceptorPanic(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Regiest(&foo)
+	s.Use(func(ctx context.Context, info *UnaryServerInfo, argv, replyv any, handler UnaryHandler) error {
+		panic("interceptor secret")
+	})
+
+	req := newTestRequest(s, "Foo.Sum", Args{Num1: 1, Num2: 3})
+	err := s.invoke(context.Background(), req)
+	_assert(errors.Is(err, ErrInternal) && !strings.Contains(err.Error(), "secret"), "expect interceptor panic to become a generic internal error, got %v", err)
+
+	s.SetRepanic(true)
+	defer func() {
+		r := recover()
+		_assert(r == "interceptor secret", "expect the interceptor panic to be repanicked, got %v", r)
+	}()
+	_ = s.invoke(context.Background(), req)
+	t.Fatal("invoke should repanic")
+}
@@ -0,0 +1,162 @@
+package nami
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits bounds the requests handled concurrently by a Server, a zero limit means unlimited.
+// A request beyond the limits waits in a bounded queue if QueueSize > 0, otherwise it's
+// rejected right away with ErrOverloaded. Streams count as requests until they end.
+type Limits struct {
+	MaxInFlight          int            // requests handled at once by the server
+	MaxInFlightPerConn   int            // requests handled at once for a connection
+	MaxInFlightPerMethod map[string]int // requests handled at once for a "Service.Method"
+	QueueSize            int            // requests waiting for a slot at once, across the server
+	QueueTimeout         time.Duration  // max wait in the queue, 0 waits until the request's deadline
+}
+
+// ErrOverloaded is returned for requests shed by the server's Limits, the caller may retry on another server
+var ErrOverloaded = NewError(CodeResourceExhausted, "rpc server: overloaded")
+
+// limiter enforces Limits with a semaphore per limit
+type limiter struct {
+	limits Limits
+	global chan struct{}
+	queued int32
+
+	mu      sync.Mutex // protect following
+	methods map[string]chan struct{}
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:  limits,
+		global:  newSemaphore(limits.MaxInFlight),
+		methods: make(map[string]chan struct{}),
+	}
+}
+
+// newSemaphore returns a semaphore of n slots, nil if n is unlimited
+func newSemaphore(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+func (l *limiter) method(serviceMethod string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sem, ok := l.methods[serviceMethod]
+	if !ok {
+		sem = newSemaphore(l.limits.MaxInFlightPerMethod[serviceMethod])
+		l.methods[serviceMethod] = sem
+	}
+	return sem
+}
+
+// admit takes the slots of a request of sc right away, or queues it. It fails if
+// there is no slot and the queue is full, a queued request must wait for its slots.
+// It's only called by the goroutine reading the requests of sc.
+func (l *limiter) admit(sc *serverConn, serviceMethod string) (*slot, error) {
+	if sc.limiter != l {
+		// the Limits changed since the last request of sc
+		sc.limiter, sc.sem = l, newSemaphore(l.limits.MaxInFlightPerConn)
+	}
+	sl := &slot{l: l}
+	for _, sem := range []chan struct{}{l.method(serviceMethod), sc.sem, l.global} {
+		if sem != nil {
+			sl.sems = append(sl.sems, sem)
+		}
+	}
+	if sl.tryAcquire() {
+		return sl, nil
+	}
+	if atomic.AddInt32(&l.queued, 1) > int32(l.limits.QueueSize) {
+		atomic.AddInt32(&l.queued, -1)
+		return nil, ErrOverloaded
+	}
+	sl.queued = true
+	return sl, nil
+}
+
+// slot holds the semaphores of a request, they're always acquired in the same order
+// (method, connection, server) so waiting requests can't deadlock each other, and a
+// request queued for a busy method doesn't hold a slot of the whole server meanwhile
+type slot struct {
+	l      *limiter
+	sems   []chan struct{}
+	held   int // number of sems acquired
+	queued bool
+}
+
+func (sl *slot) tryAcquire() bool {
+	for ; sl.held < len(sl.sems); sl.held++ {
+		select {
+		case sl.sems[sl.held] <- struct{}{}:
+		default:
+			sl.release()
+			return false
+		}
+	}
+	return true
+}
+
+// wait acquires the slots of a queued request, it gives up on queue timeout or when ctx is done
+func (sl *slot) wait(ctx context.Context) error {
+	if !sl.queued {
+		return nil
+	}
+	defer atomic.AddInt32(&sl.l.queued, -1)
+
+	var timeout <-chan time.Time
+	if d := sl.l.limits.QueueTimeout; d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for ; sl.held < len(sl.sems); sl.held++ {
+		select {
+		case sl.sems[sl.held] <- struct{}{}:
+		case <-timeout:
+			sl.release()
+			return ErrOverloaded
+		case <-ctx.Done():
+			sl.release()
+			return Errorf(ContextCode(ctx.Err()), "rpc server: request expired in queue: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// abandon gives up the slot of a request which won't be handled
+func (sl *slot) abandon() {
+	if sl.queued {
+		atomic.AddInt32(&sl.l.queued, -1)
+	}
+	sl.release()
+}
+
+func (sl *slot) release() {
+	for ; sl.held > 0; sl.held-- {
+		<-sl.sems[sl.held-1]
+	}
+}
+
+// SetLimits sets the concurrency limits of s, they apply to the requests read afterwards,
+// the requests already admitted keep the slots of the former limits
+func (s *Server) SetLimits(limits Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiter = newLimiter(limits)
+}
+
+// currentLimiter returns the limiter of s, nil if s has no Limits
+func (s *Server) currentLimiter() *limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limiter
+}
@@ -0,0 +1,123 @@
+package nami
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Logger is a levelled, structured logger. keyvals are alternating keys and values,
+// the same as log/slog, so *slog.Logger is a Logger.
+type Logger interface {
+	Debug(msg string, keyvals ...any)
+	Info(msg string, keyvals ...any)
+	Warn(msg string, keyvals ...any)
+	Error(msg string, keyvals ...any)
+}
+
+type Level int
+
+const (
+	LevelDebug Level = iota // noisy messages, e.g. each registered method or heartbeat
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{LevelDebug: "DEBUG", LevelInfo: "INFO", LevelWarn: "WARN", LevelError: "ERROR"}
+
+func (l Level) String() string {
+	if l >= 0 && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// textLogger writes a line per message, as "LEVEL msg key=value ..."
+type textLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+// NewTextLogger returns a Logger writing messages at level or above to w
+func NewTextLogger(w io.Writer, level Level) Logger {
+	return &textLogger{l: log.New(w, "", log.LstdFlags), level: level}
+}
+
+func (t *textLogger) Debug(msg string, keyvals ...any) { t.log(LevelDebug, msg, keyvals) }
+func (t *textLogger) Info(msg string, keyvals ...any)  { t.log(LevelInfo, msg, keyvals) }
+func (t *textLogger) Warn(msg string, keyvals ...any)  { t.log(LevelWarn, msg, keyvals) }
+func (t *textLogger) Error(msg string, keyvals ...any) { t.log(LevelError, msg, keyvals) }
+
+func (t *textLogger) log(level Level, msg string, keyvals []any) {
+	if level < t.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value any = "!MISSING"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		s := fmt.Sprint(value)
+		if strings.ContainsAny(s, " \t\n\"=") {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(&b, " %v=%s", keyvals[i], s)
+	}
+	_ = t.l.Output(3, b.String())
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...any) {}
+func (discardLogger) Info(string, ...any)  {}
+func (discardLogger) Warn(string, ...any)  {}
+func (discardLogger) Error(string, ...any) {}
+
+// DiscardLogger drops every message
+var DiscardLogger Logger = discardLogger{}
+
+type loggerHolder struct{ Logger }
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(loggerHolder{NewTextLogger(os.Stderr, LevelInfo)})
+}
+
+// DefaultLogger returns the Logger used where none is configured, it writes
+// messages at LevelInfo and above to stderr unless replaced by SetDefaultLogger
+func DefaultLogger() Logger {
+	return defaultLogger.Load().(loggerHolder).Logger
+}
+
+// SetDefaultLogger replaces the default Logger, nil discards every message
+func SetDefaultLogger(l Logger) {
+	if l == nil {
+		l = DiscardLogger
+	}
+	defaultLogger.Store(loggerHolder{l})
+}
+
+// SetLogger makes s log with l, nil restores the default Logger
+func (s *Server) SetLogger(l Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = l
+}
+
+func (s *Server) log() Logger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logger != nil {
+		return s.logger
+	}
+	return DefaultLogger()
+}
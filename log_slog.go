@@ -0,0 +1,15 @@
+//go:build go1.21
+
+package nami
+
+import "log/slog"
+
+var _ Logger = (*slog.Logger)(nil)
+
+// NewSlogLogger returns a Logger writing to l, slog.Default() if it's nil
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l
+}
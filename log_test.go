@@ -0,0 +1,43 @@
+package nami
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewTextLogger(&buf, LevelInfo)
+	l.Debug("dropped", "seq", 1)
+	l.Warn("rpc server: read header error", "remote", "10.0.0.1:1234", "seq", 7, "err", "unexpected EOF")
+	l.Info("odd", "key")
+
+	out := buf.String()
+	_assert(!strings.Contains(out, "dropped"), "expect debug messages below the level to be dropped: %q", out)
+	_assert(strings.Contains(out, `WARN rpc server: read header error remote=10.0.0.1:1234 seq=7 err="unexpected EOF"`), "wrong line: %q", out)
+	_assert(strings.Contains(out, "INFO odd key=!MISSING"), "expect a missing value to be marked: %q", out)
+}
+
+func TestServerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewServer()
+	s.SetLogger(NewTextLogger(&buf, LevelDebug))
+	var foo Foo
+	_assert(s.Regiest(&foo) == nil, "register failed")
+	_assert(strings.Contains(buf.String(), "DEBUG rpc server: method registered method=Foo.Sum"), "expect methods to be logged at debug: %q", buf.String())
+
+	s.SetLogger(nil)
+	_assert(s.log() == DefaultLogger(), "expect nil to restore the default logger")
+}
+
+func TestServerLogEOF(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewServer()
+	s.SetLogger(NewTextLogger(&buf, LevelDebug))
+	conn, peer := net.Pipe()
+	peer.Close()
+	s.ServeConn(conn)
+	_assert(strings.Contains(buf.String(), "DEBUG rpc server: connection closed before options") && !strings.Contains(buf.String(), "WARN"), "expect a connection closed right away to be logged at debug: %q", buf.String())
+}
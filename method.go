@@ -6,16 +6,60 @@ import (
 )
 
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64
+	method          reflect.Method
+	ArgType         reflect.Type
+	ReplyType       reflect.Type
+	numCalls        uint64
+	numNotifyErrors uint64 // one-way calls failed, their errors aren't sent to anyone
+	numPanics       uint64
+	hasContext      bool // method takes a context.Context as first argument
+	streamKind      streamKind
+	streamElem      reflect.Type // of the messages of a TypedServerStream, nil if they're untyped
+}
+
+type streamKind int
+
+const (
+	unaryMethod        streamKind = iota
+	serverStreamMethod            // func(args, ServerStream) error
+	clientStreamMethod            // func(ReceiveStream, reply) error
+	bidiStreamMethod              // func(ReceiveStream, ServerStream) error
+)
+
+// receivesStream reports whether the method's argument is a ReceiveStream
+func (m *methodType) receivesStream() bool {
+	return m.streamKind == clientStreamMethod || m.streamKind == bidiStreamMethod
+}
+
+// sendsStream reports whether the method's reply is a ServerStream
+func (m *methodType) sendsStream() bool {
+	return m.streamKind == serverStreamMethod || m.streamKind == bidiStreamMethod
+}
+
+func (m *methodType) IsStream() bool {
+	return m.streamKind != unaryMethod
+}
+
+func (m *methodType) HasContext() bool {
+	return m.hasContext
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+func (m *methodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
+func (m *methodType) NumNotifyErrors() uint64 {
+	return atomic.LoadUint64(&m.numNotifyErrors)
+}
+
+func (m *methodType) notifyFailed() {
+	atomic.AddUint64(&m.numNotifyErrors, 1)
+}
+
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
 	if m.ArgType.Kind() == reflect.Ptr {
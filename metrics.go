@@ -0,0 +1,259 @@
+package nami
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics records the calls, bytes and connections of servers or clients, and writes
+// them in the Prometheus text exposition format. It's safe for concurrent use.
+type Metrics struct {
+	namespace string // prefix of the metric names, e.g. nami_server
+	buckets   []float64
+
+	mu      sync.Mutex
+	methods map[string]*methodMetrics
+
+	bytesIn, bytesOut int64
+	connsTotal, conns int64
+}
+
+type methodMetrics struct {
+	calls    uint64
+	errors   map[Code]uint64
+	inFlight int64
+	counts   []uint64 // of each bucket, the last one is +Inf, not cumulative
+	sum      float64  // of the latencies in seconds
+}
+
+// NewServerMetrics returns Metrics named nami_server_*, each Server records to its own
+func NewServerMetrics() *Metrics {
+	return newMetrics("nami_server")
+}
+
+// NewClientMetrics returns Metrics named nami_client_*, for the Metrics of an Option
+func NewClientMetrics() *Metrics {
+	return newMetrics("nami_client")
+}
+
+// DefaultClientMetrics records the clients whose Option has no Metrics
+var DefaultClientMetrics = NewClientMetrics()
+
+func newMetrics(namespace string) *Metrics {
+	return &Metrics{namespace: namespace, buckets: DefaultBuckets, methods: make(map[string]*methodMetrics)}
+}
+
+// Begin records the start of a call of serviceMethod, the returned func records its end
+// with the error of the call. It must be called exactly once.
+func (m *Metrics) Begin(serviceMethod string) func(err error) {
+	start := time.Now()
+	m.mu.Lock()
+	mm := m.methods[serviceMethod]
+	if mm == nil {
+		mm = &methodMetrics{errors: make(map[Code]uint64), counts: make([]uint64, len(m.buckets)+1)}
+		m.methods[serviceMethod] = mm
+	}
+	mm.inFlight++
+	m.mu.Unlock()
+
+	return func(err error) {
+		elapsed := time.Since(start).Seconds()
+		i := sort.SearchFloat64s(m.buckets, elapsed)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		mm.inFlight--
+		mm.calls++
+		if err != nil {
+			mm.errors[toError(err).Code]++
+		}
+		mm.counts[i]++
+		mm.sum += elapsed
+	}
+}
+
+// Conn returns conn counting the bytes read and written as received and sent. The
+// connection is counted as open until the returned one is closed.
+func (m *Metrics) Conn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	atomic.AddInt64(&m.connsTotal, 1)
+	atomic.AddInt64(&m.conns, 1)
+	return &countingConn{ReadWriteCloser: conn, m: m}
+}
+
+type countingConn struct {
+	io.ReadWriteCloser
+	m      *Metrics
+	closed int32
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddInt64(&c.m.bytesIn, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddInt64(&c.m.bytesOut, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(&c.m.conns, -1)
+	}
+	return c.ReadWriteCloser.Close()
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	m.write(cw)
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (m *Metrics) write(w *countingWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.methods))
+	for name := range m.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.header(m.namespace+"_calls_total", "counter", "Calls finished, by method.")
+	for _, name := range names {
+		w.printf("%s_calls_total{method=%s} %d\n", m.namespace, labelValue(name), m.methods[name].calls)
+	}
+
+	w.header(m.namespace+"_errors_total", "counter", "Calls failed, by method and error code.")
+	for _, name := range names {
+		mm := m.methods[name]
+		codes := make([]Code, 0, len(mm.errors))
+		for code := range mm.errors {
+			codes = append(codes, code)
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		for _, code := range codes {
+			w.printf("%s_errors_total{method=%s,code=%s} %d\n", m.namespace, labelValue(name), labelValue(code.String()), mm.errors[code])
+		}
+	}
+
+	w.header(m.namespace+"_call_duration_seconds", "histogram", "Latency of the calls, by method.")
+	for _, name := range names {
+		mm, method := m.methods[name], labelValue(name)
+		var cumulative uint64
+		for i, count := range mm.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(m.buckets) {
+				le = strconv.FormatFloat(m.buckets[i], 'g', -1, 64)
+			}
+			w.printf("%s_call_duration_seconds_bucket{method=%s,le=%q} %d\n", m.namespace, method, le, cumulative)
+		}
+		w.printf("%s_call_duration_seconds_sum{method=%s} %s\n", m.namespace, method, strconv.FormatFloat(mm.sum, 'g', -1, 64))
+		w.printf("%s_call_duration_seconds_count{method=%s} %d\n", m.namespace, method, cumulative)
+	}
+
+	w.header(m.namespace+"_in_flight", "gauge", "Calls in flight, by method.")
+	for _, name := range names {
+		w.printf("%s_in_flight{method=%s} %d\n", m.namespace, labelValue(name), m.methods[name].inFlight)
+	}
+
+	w.header(m.namespace+"_received_bytes_total", "counter", "Bytes read from the connections.")
+	w.printf("%s_received_bytes_total %d\n", m.namespace, atomic.LoadInt64(&m.bytesIn))
+	w.header(m.namespace+"_sent_bytes_total", "counter", "Bytes written to the connections.")
+	w.printf("%s_sent_bytes_total %d\n", m.namespace, atomic.LoadInt64(&m.bytesOut))
+	w.header(m.namespace+"_connections_total", "counter", "Connections opened.")
+	w.printf("%s_connections_total %d\n", m.namespace, atomic.LoadInt64(&m.connsTotal))
+	w.header(m.namespace+"_connections", "gauge", "Connections open.")
+	w.printf("%s_connections %d\n", m.namespace, atomic.LoadInt64(&m.conns))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue quotes s as a label value of the exposition format
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// countingWriter keeps the first error and the count of bytes written
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) printf(format string, a ...any) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, a...)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *countingWriter) header(name, typ, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// MetricsHandler serves ms in the Prometheus text exposition format
+func MetricsHandler(ms ...*Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range ms {
+			if _, err := m.WriteTo(w); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// Metrics returns the metrics s records to
+func (s *Server) Metrics() *Metrics {
+	return s.metrics
+}
+
+// beginMetrics starts recording req in the metrics of s unless it's already recorded. A
+// request queued by the Limits is recorded once it's out of the queue, so its latency is
+// the one of handling it.
+func (s *Server) beginMetrics(req *request) {
+	if req.end == nil {
+		req.end = s.metrics.Begin(metricsMethod(req))
+	}
+}
+
+// finish records the end of req in the server's metrics, only the first call counts
+func (req *request) finish(err error) {
+	if req.end != nil {
+		req.end(err)
+		req.end = finished
+	}
+}
+
+// finished is the end of a recorded request
+func finished(error) {}
+
+// metricsMethod returns the method label of req, requests of unknown methods share
+// a single label so clients can't make up any number of them
+func metricsMethod(req *request) string {
+	if req.mtype == nil {
+		return "unknown"
+	}
+	return req.h.ServiceMethod
+}
@@ -0,0 +1,40 @@
+package nami
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewServerMetrics()
+	m.Begin("Foo.Sum")(nil)
+	m.Begin("Foo.Sum")(NewError(CodeNotFound, "missing"))
+	m.Begin(`Odd"Name`)(errors.New("boom"))
+	end := m.Begin("Foo.Sleep")
+
+	w := httptest.NewRecorder()
+	MetricsHandler(m).ServeHTTP(w, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+	out := w.Body.String()
+	_assert(strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4"), "wrong content type")
+	for _, want := range []string{
+		"# TYPE nami_server_calls_total counter\n",
+		`nami_server_calls_total{method="Foo.Sum"} 2`,
+		`nami_server_errors_total{method="Foo.Sum",code="NotFound"} 1`,
+		`nami_server_errors_total{method="Odd\"Name",code="Unknown"} 1`,
+		"# TYPE nami_server_call_duration_seconds histogram\n",
+		`nami_server_call_duration_seconds_bucket{method="Foo.Sum",le="0.001"} 2`,
+		`nami_server_call_duration_seconds_bucket{method="Foo.Sum",le="+Inf"} 2`,
+		`nami_server_call_duration_seconds_count{method="Foo.Sum"} 2`,
+		`nami_server_in_flight{method="Foo.Sleep"} 1`,
+		`nami_server_calls_total{method="Foo.Sleep"} 0`,
+	} {
+		_assert(strings.Contains(out, want), "expect %q in:\n%s", want, out)
+	}
+
+	end(nil)
+	w = httptest.NewRecorder()
+	MetricsHandler(m).ServeHTTP(w, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+	_assert(strings.Contains(w.Body.String(), `nami_server_in_flight{method="Foo.Sleep"} 0`), "expect the call to be finished")
+}
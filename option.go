@@ -1,23 +1,118 @@
 package nami
 
 import (
+	"crypto/tls"
+	"io"
 	"time"
 
 	"github.com/xeasy/nami/codec"
 )
 
-const MagicNumber = 0x3bef5c
+// MagicNumber marks the Option of a client expecting the server's Handshake in reply
+const MagicNumber = 0x3bef5d
+
+// LegacyMagicNumber marks the Option of a client predating the Handshake. The server
+// replies nothing and serves it with its CodecType, uncompressed. Clients set it to talk
+// to servers predating the Handshake, which only accept this magic number.
+const LegacyMagicNumber = 0x3bef5c
 
 type Option struct {
 	MagicNumber int
 	CodecType   codec.Type
+	// CodecTypes offers codecs in order of preference, the server picks the first one it supports.
+	// CodecType is offered alone if it's empty
+	CodecTypes []codec.Type
+	// CompressType compresses bodies larger than CompressThreshold (codec.DefaultCompressThreshold if it's 0)
+	CompressType      codec.CompressType
+	CompressThreshold int
 
 	ConnectionTimeout time.Duration
 	HandleTimeout     time.Duration
+
+	// StreamWindow is how many messages of a stream may be sent before the receiver
+	// acknowledges them, DefaultStreamWindow if it's 0. Servers refuse windows above MaxStreamWindow
+	StreamWindow int
+
+	// TLSConfig makes the client speak TLS, it's not sent to server. ServerName defaults to
+	// the dialed host, set Certificates for mutual TLS
+	TLSConfig *tls.Config `json:"-"`
+
+	// Credentials authenticates the client to the server's Authenticator, it's not sent to server
+	Credentials Credentials `json:"-"`
+	// Credential is sent in place of Credentials, it's filled by the client from Credentials.Credential
+	Credential string `json:",omitempty"`
+
+	// Logger is the logger of the client, DefaultLogger() if it's nil. It's not sent to server
+	Logger Logger `json:"-"`
+	// Metrics records the calls and connections of the client, DefaultClientMetrics if it's nil.
+	// It's not sent to server
+	Metrics *Metrics `json:"-"`
 }
 
+const DefaultStreamWindow = 16
+
+// MaxStreamWindow bounds the StreamWindow of a client, the server buffers that many messages of a stream
+const MaxStreamWindow = 1024
+
 var DefaultOption = &Option{
 	MagicNumber:       MagicNumber,
 	CodecType:         codec.GobType,
 	ConnectionTimeout: time.Second * 10,
 }
+
+// Log returns the Logger of opt, DefaultLogger() if opt or its Logger is nil
+func (opt *Option) Log() Logger {
+	if opt != nil && opt.Logger != nil {
+		return opt.Logger
+	}
+	return DefaultLogger()
+}
+
+// ClientMetrics returns the Metrics of opt, DefaultClientMetrics if opt or its Metrics is nil
+func (opt *Option) ClientMetrics() *Metrics {
+	if opt != nil && opt.Metrics != nil {
+		return opt.Metrics
+	}
+	return DefaultClientMetrics
+}
+
+// Offer returns the codec types the client offers to the server
+func (opt *Option) Offer() []codec.Type {
+	if len(opt.CodecTypes) > 0 {
+		return opt.CodecTypes
+	}
+	return []codec.Type{opt.CodecType}
+}
+
+// StreamWindowSize returns the stream window in effect
+func (opt *Option) StreamWindowSize() int {
+	if opt.StreamWindow > 0 {
+		return opt.StreamWindow
+	}
+	return DefaultStreamWindow
+}
+
+// Handshake is the server's reply to the Option sent by client. While Challenge isn't
+// empty, the client must answer it with an AuthResponse and read the next Handshake.
+type Handshake struct {
+	CodecType    codec.Type         // the codec picked by server
+	CompressType codec.CompressType // codec.NoCompress if server doesn't support the requested one
+	Challenge    []byte             `json:",omitempty"`
+	Error        string
+	Code         uint32 // status code of Error
+}
+
+// AuthResponse is the client's answer to the Challenge of a Handshake
+type AuthResponse struct {
+	Response []byte
+	Error    string // the client can't answer
+}
+
+// NewCodec makes the codec used on conn, wrapping it with compression if any
+func (opt *Option) NewCodec(f codec.NewCodecFunc, conn io.ReadWriteCloser, compress codec.CompressType) codec.Codec {
+	cc := f(conn)
+	if compress != codec.NoCompress {
+		cc = codec.NewCompressCodec(cc, compress, opt.CompressThreshold)
+	}
+	return cc
+}
@@ -0,0 +1,220 @@
+package nami
+
+import (
+	"container/list"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for requests rejected by a RateLimiter of the server
+var ErrRateLimited = NewError(CodeResourceExhausted, "rpc server: rate limited")
+
+// RateLimitKey returns the key a request is rate limited by, requests with an empty key aren't limited
+type RateLimitKey func(info *RequestInfo) string
+
+// KeyByServiceMethod rate limits each "Service.Method" on its own
+func KeyByServiceMethod(info *RequestInfo) string {
+	return info.ServiceMethod
+}
+
+// KeyByRemoteAddr rate limits each client host on its own, whichever connection it uses
+func KeyByRemoteAddr(info *RequestInfo) string {
+	host, _, err := net.SplitHostPort(info.RemoteAddr)
+	if err != nil {
+		return info.RemoteAddr
+	}
+	return host
+}
+
+// KeyByMetadata rate limits requests by the value of the metadata field, e.g. a tenant id.
+// Requests without the field aren't limited.
+func KeyByMetadata(field string) RateLimitKey {
+	return func(info *RequestInfo) string {
+		return info.Metadata[field]
+	}
+}
+
+// maxBuckets is the number of keys a RateLimiter keeps a bucket for, beyond it the least
+// recently used bucket is dropped. It's most likely full, which behaves the same as a new one.
+const maxBuckets = 4096
+
+// RateLimiter limits the rate of requests with a token bucket per key: a bucket holds
+// up to burst tokens and gets rate tokens per second, each request takes a token.
+type RateLimiter struct {
+	name  string
+	key   RateLimitKey
+	rate  float64
+	burst float64
+
+	mu       sync.Mutex // protect following
+	buckets  map[string]*list.Element
+	lru      *list.List // of *bucket, the most recently used first
+	allowed  uint64
+	rejected uint64
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter named name, which limits requests sharing a key
+// to rate per second with bursts of up to burst requests
+func NewRateLimiter(name string, key RateLimitKey, rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		name:    name,
+		key:     key,
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Allow reports whether a request of key may be handled now, taking a token if so
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	e, ok := rl.buckets[key]
+	if ok {
+		rl.lru.MoveToFront(e)
+	} else {
+		if rl.lru.Len() >= maxBuckets {
+			delete(rl.buckets, rl.lru.Remove(rl.lru.Back()).(*bucket).key)
+		}
+		e = rl.lru.PushFront(&bucket{key: key, tokens: rl.burst, last: now})
+		rl.buckets[key] = e
+	}
+	b := e.Value.(*bucket)
+	rl.refill(b, now)
+	if b.tokens < 1 {
+		rl.rejected++
+		return false
+	}
+	b.tokens--
+	rl.allowed++
+	return true
+}
+
+// ready reports whether a request of key would be allowed now without taking a token,
+// a request which wouldn't is counted as rejected
+func (rl *RateLimiter) ready(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	e, ok := rl.buckets[key]
+	if !ok {
+		return true
+	}
+	b := e.Value.(*bucket)
+	if rl.refill(b, time.Now()); b.tokens < 1 {
+		rl.rejected++
+		return false
+	}
+	return true
+}
+
+// refund gives back the token taken by Allow for a request which isn't handled after all
+func (rl *RateLimiter) refund(key string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if e, ok := rl.buckets[key]; ok {
+		b := e.Value.(*bucket)
+		if b.tokens++; b.tokens > rl.burst {
+			b.tokens = rl.burst
+		}
+		rl.allowed--
+	}
+}
+
+func (rl *RateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+}
+
+// RateLimiterState is a snapshot of a RateLimiter
+type RateLimiterState struct {
+	Name     string
+	Rate     float64
+	Burst    int
+	Allowed  uint64
+	Rejected uint64
+	Buckets  []BucketState // sorted by key
+}
+
+// BucketState is the tokens left for a key
+type BucketState struct {
+	Key    string
+	Tokens float64
+}
+
+// State returns the current state of rl
+func (rl *RateLimiter) State() RateLimiterState {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	state := RateLimiterState{
+		Name:     rl.name,
+		Rate:     rl.rate,
+		Burst:    int(rl.burst),
+		Allowed:  rl.allowed,
+		Rejected: rl.rejected,
+		Buckets:  make([]BucketState, 0, len(rl.buckets)),
+	}
+	for e := rl.lru.Front(); e != nil; e = e.Next() {
+		b := e.Value.(*bucket)
+		rl.refill(b, now)
+		state.Buckets = append(state.Buckets, BucketState{Key: b.key, Tokens: b.tokens})
+	}
+	sort.Slice(state.Buckets, func(i, j int) bool { return state.Buckets[i].Key < state.Buckets[j].Key })
+	return state
+}
+
+// AddRateLimiter makes s reject requests beyond rl with ErrRateLimited, a request
+// must be allowed by every rate limiter of s
+func (s *Server) AddRateLimiter(rl *RateLimiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimiters = append(s.rateLimiters[:len(s.rateLimiters):len(s.rateLimiters)], rl)
+}
+
+// allow checks the request of info against the rate limiters of s, the tokens are only
+// taken once every rate limiter would allow it, so a rejected request uses up none of them
+func (s *Server) allow(info *RequestInfo) error {
+	s.mu.Lock()
+	rateLimiters := s.rateLimiters
+	s.mu.Unlock()
+
+	keys := make([]string, len(rateLimiters))
+	for i, rl := range rateLimiters {
+		keys[i] = rl.key(info)
+		if keys[i] != "" && !rl.ready(keys[i]) {
+			return ErrRateLimited.WithDetail("limiter", rl.name)
+		}
+	}
+	for i, rl := range rateLimiters {
+		if keys[i] != "" && !rl.Allow(keys[i]) {
+			// a concurrent request took the last token since the check
+			for j := 0; j < i; j++ {
+				if keys[j] != "" {
+					rateLimiters[j].refund(keys[j])
+				}
+			}
+			return ErrRateLimited.WithDetail("limiter", rl.name)
+		}
+	}
+	return nil
+}
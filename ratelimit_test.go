@@ -0,0 +1,66 @@
+package nami
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter("tenant", KeyByMetadata("tenant"), 20, 2)
+	_assert(rl.Allow("a") && rl.Allow("a"), "expect a burst of 2 to be allowed")
+	_assert(!rl.Allow("a"), "expect requests beyond the burst to be rejected")
+	_assert(rl.Allow("b"), "expect keys to be limited on their own")
+
+	time.Sleep(time.Millisecond * 60)
+	_assert(rl.Allow("a"), "expect the bucket to be refilled")
+
+	state := rl.State()
+	_assert(state.Allowed == 4 && state.Rejected == 1, "wrong counts: %+v", state)
+	_assert(len(state.Buckets) == 2 && state.Buckets[0].Key == "a", "wrong buckets: %+v", state.Buckets)
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	rl := NewRateLimiter("tenant", KeyByMetadata("tenant"), 1, 1)
+	_assert(rl.Allow("first") && !rl.Allow("first"), "expect the burst of first to be used up")
+	for i := 0; i < maxBuckets; i++ {
+		rl.Allow(strconv.Itoa(i))
+		if i == maxBuckets/2 {
+			_assert(!rl.Allow("first"), "expect first to stay limited while it's used")
+		}
+	}
+	_assert(len(rl.buckets) == maxBuckets && rl.lru.Len() == maxBuckets, "expect at most %d buckets, got %d", maxBuckets, len(rl.buckets))
+	_assert(rl.buckets["0"] == nil, "expect the least recently used bucket to be dropped")
+	_assert(rl.buckets["first"] != nil, "expect a recently used bucket to be kept")
+}
+
+func TestServerRateLimit(t *testing.T) {
+	s := NewServer()
+	s.AddRateLimiter(NewRateLimiter("per-host", KeyByRemoteAddr, 1, 1))
+	s.AddRateLimiter(NewRateLimiter("per-tenant", KeyByMetadata("tenant"), 1, 1))
+
+	info := &RequestInfo{ServiceMethod: "Foo.Sum", RemoteAddr: "10.0.0.1:1234"}
+	_assert(s.allow(info) == nil, "expect the first request to be allowed")
+	info.RemoteAddr = "10.0.0.1:5678"
+	err := s.allow(info)
+	_assert(errors.Is(err, ErrRateLimited) && toError(err).Details["limiter"] == "per-host", "expect the host to be limited, got %v", err)
+
+	info = &RequestInfo{RemoteAddr: "10.0.0.2:1234", Metadata: map[string]string{"tenant": "acme"}}
+	_assert(s.allow(info) == nil, "expect the first request of tenant to be allowed")
+	info.RemoteAddr = "10.0.0.3:1234"
+	_assert(errors.Is(s.allow(info), ErrRateLimited), "expect the tenant to be limited")
+	info.Metadata = nil
+	_assert(s.allow(info) == nil, "expect a request rejected by a later limiter not to use up the earlier ones")
+
+	info = &RequestInfo{RemoteAddr: "10.0.0.4:1234", Metadata: map[string]string{"tenant": "<script>alert(1)</script>"}}
+	_assert(s.allow(info) == nil, "expect the first request of tenant to be allowed")
+
+	w := httptest.NewRecorder()
+	debugHTTP{s}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	page := w.Body.String()
+	_assert(strings.Contains(page, "Rate limiter per-tenant") && strings.Contains(page, "acme"), "expect limiter state on the debug page, got %s", page)
+	_assert(!strings.Contains(page, "<script>") && strings.Contains(page, "&lt;script&gt;"), "expect keys to be escaped on the debug page, got %s", page)
+}
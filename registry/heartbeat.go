@@ -1,11 +1,34 @@
 package registry
 
 import (
-	"fmt"
 	"net/http"
+	"sync"
 	"time"
+
+	"github.com/xeasy/nami"
+)
+
+var (
+	heartbeatMu     sync.Mutex
+	heartbeatLogger nami.Logger
 )
 
+// SetHeartbeatLogger makes Heartbeat log with l, nil restores nami.DefaultLogger()
+func SetHeartbeatLogger(l nami.Logger) {
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+	heartbeatLogger = l
+}
+
+func heartbeatLog() nami.Logger {
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+	if heartbeatLogger != nil {
+		return heartbeatLogger
+	}
+	return nami.DefaultLogger()
+}
+
 func Heartbeat(regiestry, addr string, duration time.Duration) {
 	if duration == 0 {
 		duration = defaultTimeout - time.Second*60
@@ -25,12 +48,12 @@ func Heartbeat(regiestry, addr string, duration time.Duration) {
 }
 
 func sendHeartbeat(regiestry, addr string) error {
-	fmt.Println("rpc server: ", addr, " sending heartbeat to regiestry ", regiestry)
+	heartbeatLog().Debug("rpc server: send heartbeat", "addr", addr, "registry", regiestry)
 	client := &http.Client{}
 	req, _ := http.NewRequest("POST", regiestry, nil)
 	req.Header.Set("X-Namirpc-Server", addr)
 	if _, err := client.Do(req); err != nil {
-		fmt.Println("rpc server: Heartbeat error ", err)
+		heartbeatLog().Warn("rpc server: heartbeat error", "addr", addr, "registry", regiestry, "err", err)
 		return err
 	}
 	return nil
@@ -1,12 +1,13 @@
 package registry
 
 import (
-	"fmt"
 	"net/http"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/xeasy/nami"
 )
 
 // ServerItem represent as rpc server obj
@@ -19,6 +20,7 @@ type Registry struct {
 	timeout time.Duration
 	mu      sync.Mutex
 	servers map[string]*ServerItem
+	logger  nami.Logger
 }
 
 const (
@@ -35,15 +37,33 @@ func New(time time.Duration) *Registry {
 
 var DefaultRegiestry = New(defaultTimeout)
 
-func (r *Registry) putServer(addr string) {
+// SetLogger makes r log with l, nil restores nami.DefaultLogger()
+func (r *Registry) SetLogger(l nami.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = l
+}
+
+func (r *Registry) log() nami.Logger {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.logger != nil {
+		return r.logger
+	}
+	return nami.DefaultLogger()
+}
+
+func (r *Registry) putServer(addr string) {
+	r.mu.Lock()
 	s := r.servers[addr]
-	if s == nil {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
-	} else {
+	if s != nil {
 		s.start = time.Now()
+		r.mu.Unlock()
+		return
 	}
+	r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
+	r.mu.Unlock()
+	r.log().Info("rpc registry: server added", "addr", addr)
 }
 
 func (r *Registry) aliveServers() []string {
@@ -79,7 +99,7 @@ func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func (r *Registry) HandlHTTP(registryPath string) {
 	http.Handle(registryPath, r)
-	fmt.Println("rpc server: regiest path ", registryPath)
+	r.log().Info("rpc registry: serving", "path", registryPath)
 }
 
 func HandlHTTP() {
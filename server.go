@@ -1,15 +1,21 @@
 package nami
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go/ast"
 	"io"
 	"net"
 	"net/http"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xeasy/nami/codec"
@@ -19,6 +25,8 @@ const (
 	Connected        = "200 OK Connected to Nami RPC"
 	DefaultRPCPath   = "/_namirpc_"
 	DefaultDebugPath = "/debug/namirpc"
+	// DefaultMetricsPath serves the metrics of the server and DefaultClientMetrics in the Prometheus text format
+	DefaultMetricsPath = "/debug/namirpc/metrics"
 )
 
 type request struct {
@@ -26,6 +34,10 @@ type request struct {
 	svc          *service
 	mtype        *methodType
 	argv, replyv reflect.Value
+	stream       *serverStream // of a streaming method
+	slot         *slot         // of the server's Limits
+	info         *RequestInfo
+	end          func(err error) // records the end of the request in the server's metrics
 }
 
 type NServer interface {
@@ -33,7 +45,7 @@ type NServer interface {
 	ServeConn(conn io.ReadWriteCloser)
 	Regiest(rcvr any) error
 	findService(serviceMethod string) (svc *service, mtype *methodType)
-	serveCodec(cc codec.Codec)
+	serveCodec(sc *serverConn)
 	readRequestHeader(cc codec.Codec) (h *codec.Header, err error)
 	readRequest(cc codec.Codec) (*request, error)
 	handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup)
@@ -43,18 +55,53 @@ type NServer interface {
 // Server represents an RPC Server.
 type Server struct {
 	serviceMap sync.Map
+
+	mu            sync.Mutex // protect following
+	listeners     map[net.Listener]struct{}
+	conns         map[*serverConn]struct{}
+	inShutdown    bool
+	interceptors  []UnaryServerInterceptor
+	repanic       bool
+	limiter       *limiter
+	rateLimiters  []*RateLimiter
+	authenticator Authenticator
+	policy        *Policy
+	logger        Logger
+	metrics       *Metrics
+
+	numNotifyErrors uint64 // one-way calls failed before their method is found, e.g. misrouted ones
 }
 
 var DefaultServer *Server
 var invalidRequest = struct{}{}
 
+var ErrServerClosed = NewError(CodeUnavailable, "rpc server: server closed")
+
 func init() {
 	DefaultServer = NewServer()
 }
 
 // NewServer return a new Server.
 func NewServer() *Server {
-	return &Server{}
+	return &Server{
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+		metrics:   NewServerMetrics(),
+	}
+}
+
+// NumNotifyErrors returns how many one-way calls failed before their method is found, e.g. the
+// ones to unknown methods. Failures of known methods are counted by their method.
+func (s *Server) NumNotifyErrors() uint64 {
+	return atomic.LoadUint64(&s.numNotifyErrors)
+}
+
+// SetRepanic makes a panicking service method panic again once its stack is logged,
+// crashing the server instead of replying an internal error. It's meant for debugging.
+func (s *Server) SetRepanic(repanic bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repanic = repanic
 }
 
 func Accept(l net.Listener) {
@@ -70,7 +117,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		fmt.Println("rpc server: rpc Hijack err:", err)
+		s.log().Error("rpc server: hijack error", "remote", req.RemoteAddr, "err", err)
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+Connected+"\n\n")
@@ -81,7 +128,8 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (s *Server) HandleHTTP() {
 	http.Handle(DefaultRPCPath, s)
 	http.Handle(DefaultDebugPath, debugHTTP{s})
-	fmt.Printf("rpc server debug path: %s \n", DefaultDebugPath)
+	http.Handle(DefaultMetricsPath, MetricsHandler(s.metrics, DefaultClientMetrics))
+	s.log().Info("rpc server: debug path registered", "path", DefaultDebugPath, "metrics", DefaultMetricsPath)
 }
 
 func HandleHTTP() {
@@ -90,9 +138,15 @@ func HandleHTTP() {
 
 func (s *Server) Regiest(rcvr any) error {
 	service := newService(rcvr)
+	if !ast.IsExported(service.name) {
+		s.log().Warn("rpc server: service name isn't exported", "service", service.name)
+	}
 	if _, dup := s.serviceMap.LoadOrStore(service.name, service); dup {
 		return errors.New("rpc server: service regiest fail, already defined: " + service.name)
 	}
+	for name := range service.method {
+		s.log().Debug("rpc server: method registered", "method", service.name+"."+name)
+	}
 	return nil
 }
 
@@ -103,73 +157,315 @@ func Regiest(rcvr any) error {
 func (s *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = NewError(CodeInvalidArgument, "rpc server: service/method request ill-formed: "+serviceMethod)
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svcIntface, ok := s.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+		err = NewError(CodeNotFound, "rpc server: can't find service "+serviceName)
 		return
 	}
 
 	svc = svcIntface.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = NewError(CodeNotFound, "rpc server: can't find method "+methodName)
 		return
 	}
 	return
 }
 
 func (s *Server) Accept(lis net.Listener) {
+	if !s.trackListener(lis, true) {
+		lis.Close()
+		return
+	}
+	defer s.trackListener(lis, false)
+
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			fmt.Println("rpc server: accept error: ", err)
+			if !s.shuttingDown() {
+				s.log().Error("rpc server: accept error", "addr", lis.Addr(), "err", err)
+			}
 			return
 		}
-		s.ServeConn(conn)
+		go s.ServeConn(conn)
 	}
 }
+
+// AcceptTLS accepts connections on lis and serves them over TLS with config. Set
+// config.ClientAuth to tls.RequireAndVerifyClientCert for mutual TLS, the verified
+// client certificate is then available in the RequestInfo of each request.
+func (s *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	s.Accept(tls.NewListener(lis, config))
+}
+
+func AcceptTLS(lis net.Listener, config *tls.Config) {
+	DefaultServer.AcceptTLS(lis, config)
+}
+
 func (s *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { conn.Close() }()
 
+	var remoteAddr string
+	if nc, ok := conn.(net.Conn); ok {
+		remoteAddr = nc.RemoteAddr().String()
+	}
+
+	var peerCert *x509.Certificate
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			s.log().Warn("rpc server: tls handshake error", "remote", remoteAddr, "err", err)
+			return
+		}
+		// only a certificate verified against the config's ClientCAs identifies the peer
+		if chains := tc.ConnectionState().VerifiedChains; len(chains) > 0 {
+			peerCert = chains[0][0]
+		}
+	}
+	conn = s.metrics.Conn(conn)
+
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		fmt.Println("rcp server: get options error: ", err)
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		if err == io.EOF {
+			// e.g. a health check or port scan connecting without a word
+			s.log().Debug("rpc server: connection closed before options", "remote", remoteAddr)
+		} else {
+			s.log().Warn("rpc server: get options error", "remote", remoteAddr, "err", err)
+		}
+		return
+	}
+
+	// legacy clients expect no handshake, they're served with the codec they ask for or dropped
+	legacy := opt.MagicNumber == LegacyMagicNumber
+	reject := func(hs *Handshake) {
+		if !legacy {
+			s.sendHandshake(conn, hs)
+		}
+	}
+
+	if s.shuttingDown() {
+		reject(&Handshake{Error: ErrServerClosed.Error(), Code: uint32(CodeUnavailable)})
 		return
 	}
 
-	if opt.MagicNumber != MagicNumber {
-		fmt.Println("rpc server: invalid MagicNumber :", opt.MagicNumber)
+	if opt.MagicNumber != MagicNumber && !legacy {
+		s.log().Warn("rpc server: invalid magic number", "remote", remoteAddr, "magic", opt.MagicNumber)
+		s.sendHandshake(conn, &Handshake{Error: fmt.Sprintf("rpc server: invalid MagicNumber %x", opt.MagicNumber), Code: uint32(CodeInvalidArgument)})
+		return
+	}
+	if opt.StreamWindow > MaxStreamWindow {
+		s.log().Warn("rpc server: stream window too large", "remote", remoteAddr, "window", opt.StreamWindow)
+		reject(&Handshake{Error: fmt.Sprintf("rpc server: stream window %d exceeds %d", opt.StreamWindow, MaxStreamWindow), Code: uint32(CodeInvalidArgument)})
 		return
 	}
 
-	codecFunc := codec.NewCodecFuncMap[opt.CodecType]
+	var principal string
+	if auth := s.getAuthenticator(); auth != nil {
+		challenge := s.challenger(conn, dec)
+		if legacy {
+			challenge = func([]byte) ([]byte, error) {
+				return nil, errors.New("rpc server: legacy client can't answer a challenge")
+			}
+		}
+		var err error
+		if principal, err = auth.Authenticate(&opt, challenge); err != nil {
+			keyvals := []any{"remote", remoteAddr, "err", err}
+			if cause := errors.Unwrap(err); cause != nil {
+				keyvals = append(keyvals, "cause", cause)
+			}
+			s.log().Warn("rpc server: authenticate error", keyvals...)
+			code := toError(err).Code
+			if code == CodeUnknown {
+				code = CodeUnauthenticated
+			}
+			reject(&Handshake{Error: err.Error(), Code: uint32(code)})
+			return
+		}
+	}
+
+	var codecFunc codec.NewCodecFunc
+	hs := &Handshake{}
+	for _, typ := range opt.Offer() {
+		if codecFunc = codec.Lookup(typ); codecFunc != nil {
+			hs.CodecType = typ
+			break
+		}
+	}
 	if codecFunc == nil {
-		fmt.Println("rpc server: invalid codec type : ", opt.CodecType)
+		s.log().Warn("rpc server: no supported codec", "remote", remoteAddr, "offer", opt.Offer())
+		hs.Error = fmt.Sprintf("rpc server: no supported codec in %v", opt.Offer())
+		hs.Code = uint32(CodeInvalidArgument)
+		reject(hs)
 		return
 	}
-	s.serveCodec(codecFunc(conn), &opt)
+	if !legacy {
+		if codec.IsSupportedCompress(opt.CompressType) {
+			hs.CompressType = opt.CompressType
+		}
+		if err := s.sendHandshake(conn, hs); err != nil {
+			return
+		}
+	}
+
+	// the json decoder may have read ahead into the first request, hand those bytes
+	// (minus the newline terminating the option) to the codec
+	buffered, _ := io.ReadAll(dec.Buffered())
+	buffered = bytes.TrimPrefix(buffered, []byte("\n"))
+	conn = &bufferedConn{ReadWriteCloser: conn, r: io.MultiReader(bytes.NewReader(buffered), conn)}
+	sc := newServerConn(opt.NewCodec(codecFunc, conn, hs.CompressType), &opt, remoteAddr)
+	sc.peerCert = peerCert
+	sc.principal = principal
+	s.serveCodec(sc)
+}
+
+// sendHandshake replies the Option, it's written without a trailing newline
+// so the client's json decoder never reads ahead into the first response
+func (s *Server) sendHandshake(conn io.Writer, hs *Handshake) error {
+	b, err := json.Marshal(hs)
+	if err == nil {
+		_, err = conn.Write(b)
+	}
+	if err != nil {
+		s.log().Warn("rpc server: send handshake error", "err", err)
+	}
+	return err
+}
+
+// challenger returns the Challenger of an Authenticator, sending challenges within handshakes
+func (s *Server) challenger(conn io.Writer, dec *json.Decoder) Challenger {
+	return func(challenge []byte) ([]byte, error) {
+		if len(challenge) == 0 {
+			return nil, errors.New("rpc server: empty challenge")
+		}
+		if err := s.sendHandshake(conn, &Handshake{Challenge: challenge}); err != nil {
+			return nil, err
+		}
+		var resp AuthResponse
+		if err := dec.Decode(&resp); err != nil {
+			return nil, err
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		return resp.Response, nil
+	}
 }
 
-func (s *Server) serveCodec(cc codec.Codec, opt *Option) {
-	var sending sync.Mutex
-	var wg sync.WaitGroup
+// authenticateRequest authenticates the request of info if the Authenticator of s does it
+// for each request, the principal of the request replaces the connection's one
+func (s *Server) authenticateRequest(info *RequestInfo) error {
+	auth, ok := s.getAuthenticator().(RequestAuthenticator)
+	if !ok {
+		return nil
+	}
+	principal, err := auth.AuthenticateRequest(info)
+	if err != nil {
+		if e := toError(err); e.Code == CodeUnknown {
+			return Errorf(CodeUnauthenticated, "rpc server: unauthenticated: %w", err)
+		}
+		return err
+	}
+	info.Principal = principal
+	return nil
+}
+
+// bufferedConn reads from r before falling through to the underlying conn
+type bufferedConn struct {
+	io.ReadWriteCloser
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (s *Server) serveCodec(sc *serverConn) {
+	cc := sc.cc
+	if !s.trackConn(sc, true) {
+		return
+	}
+	defer s.trackConn(sc, false)
+
 	for {
 		req, err := s.readRequest(cc)
+		if err == nil && isControl(req.h.Kind) {
+			if err = s.handleControl(sc, req.h); err != nil {
+				break
+			}
+			continue
+		}
 		if err != nil {
-			fmt.Println("rpc server: read request error: ", err)
 			if req == nil {
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					s.log().Warn("rpc server: read header error", "remote", sc.remoteAddr, "err", err)
+				}
 				break
 			}
-			req.h.Error = err.Error()
-			s.sendResponse(cc, req.h, invalidRequest, &sending)
+			s.log().Warn("rpc server: read request error", "remote", sc.remoteAddr, "seq", req.h.Seq, "method", req.h.ServiceMethod, "err", err)
+			s.replyError(sc, req, err)
+			continue
 		}
-		wg.Add(1)
-		go s.handleRequest(cc, req, &sending, &wg, opt.HandleTimeout)
+		if s.shuttingDown() {
+			// the client hasn't seen the GoAway yet, it may retry elsewhere
+			s.replyError(sc, req, ErrServerClosed)
+			continue
+		}
+		req.info = &RequestInfo{
+			ServiceMethod:   req.h.ServiceMethod,
+			Seq:             req.h.Seq,
+			RemoteAddr:      sc.remoteAddr,
+			Metadata:        req.h.Metadata,
+			PeerCertificate: sc.peerCert,
+			Principal:       sc.principal,
+		}
+		if err = s.authenticateRequest(req.info); err != nil {
+			s.replyError(sc, req, err)
+			continue
+		}
+		if err = s.authorize(req.info); err != nil {
+			s.replyError(sc, req, err)
+			continue
+		}
+		if err = s.allow(req.info); err != nil {
+			s.replyError(sc, req, err)
+			continue
+		}
+		if l := s.currentLimiter(); l != nil {
+			if req.slot, err = l.admit(sc, req.h.ServiceMethod); err != nil {
+				s.replyError(sc, req, err)
+				continue
+			}
+		}
+
+		// the tighter one of client's deadline and HandleTimeout
+		timeout := sc.opt.HandleTimeout
+		if req.h.Timeout > 0 && (timeout == 0 || req.h.Timeout < timeout) {
+			timeout = req.h.Timeout
+		}
+		ctx, ok := sc.begin(req.h.Seq, timeout)
+		if !ok {
+			if req.slot != nil {
+				req.slot.abandon()
+			}
+			// the connection is closed by Shutdown, the reply reaches the client if it's still writable
+			s.replyError(sc, req, ErrServerClosed)
+			break
+		}
+		if req.mtype.IsStream() {
+			// track the stream before reading on, the client may send messages right after opening it
+			req.stream = newServerStream(ctx, sc, req.h.Seq)
+			sc.trackStream(req.stream, true)
+		}
+		go s.handleRequest(ctx, sc, req, timeout)
 	}
+	// the connection is broken, cancel the handling requests
+	sc.cancel()
+	sc.wg.Wait()
+	sc.close()
 }
 
 func (s *Server) readRequest(cc codec.Codec) (*request, error) {
@@ -178,12 +474,32 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 		return nil, err
 	}
 	req := &request{h: h}
+	if isControl(h.Kind) {
+		// the body is read by handleControl
+		return req, nil
+	}
 	req.svc, req.mtype, err = s.findService(h.ServiceMethod)
+	if err == nil && req.mtype.IsStream() != (h.Kind == codec.KindStream) {
+		err = NewError(CodeInvalidArgument, "rpc server: streaming method must be called by stream, others mustn't: "+h.ServiceMethod)
+	}
 	if err != nil {
+		// discard the body, so the next request can be read
+		if bodyErr := cc.ReadBody(nil); bodyErr != nil {
+			s.log().Warn("rpc server: discard body error", "seq", h.Seq, "method", h.ServiceMethod, "err", bodyErr)
+		}
 		return req, err
 	}
+	if !req.mtype.sendsStream() {
+		req.replyv = req.mtype.newReplyv()
+	}
+	if req.mtype.receivesStream() {
+		// messages come along the stream, the body is empty
+		if err = cc.ReadBody(nil); err != nil {
+			return req, Errorf(CodeInvalidArgument, "rpc server: read body fail: %w", err)
+		}
+		return req, nil
+	}
 	req.argv = req.mtype.newArgv()
-	req.replyv = req.mtype.newReplyv()
 
 	// make sure that argvi is a pointer, Readbody need a pointer as parameter
 	argvi := req.argv.Interface()
@@ -191,8 +507,7 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
-		fmt.Println("rcp server: read body fail: ", err)
-		return req, err
+		return req, Errorf(CodeInvalidArgument, "rpc server: read body fail: %w", err)
 	}
 	return req, nil
 }
@@ -200,44 +515,115 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			fmt.Println("rpc server: read header error: ", err)
-		}
 		return nil, err
 	}
 	return &h, nil
 }
 
-func (s *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
+func (s *Server) handleRequest(ctx context.Context, sc *serverConn, req *request, timeout time.Duration) {
+	defer sc.end(req.h.Seq)
+	cc, sending := sc.cc, &sc.sending
+
+	if req.slot != nil {
+		if err := req.slot.wait(ctx); err != nil {
+			if req.stream != nil {
+				sc.trackStream(req.stream, false)
+			}
+			if ctx.Err() != context.Canceled {
+				s.replyError(sc, req, err)
+			}
+			s.beginMetrics(req)
+			req.finish(err)
+			return
+		}
+		defer req.slot.release()
+	}
+	s.beginMetrics(req)
+
+	info := req.info
+	ctx = withRequestInfo(ctx, info)
+	if req.mtype.IsStream() {
+		s.handleStream(ctx, sc, req, info)
+		return
+	}
 
+	called := make(chan error, 1)
+	returned := make(chan struct{})
 	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		close(called)
+		defer close(returned)
+		called <- s.invoke(ctx, req)
+	}()
+	// the slot and the request are held until the method returns, even after a timeout is
+	// replied, so the Limits and Shutdown account for the methods actually running
+	defer func() { <-returned }()
+
+	if req.h.Kind == codec.KindNotify {
+		s.handleNotify(ctx, req, called)
+		return
+	}
+
+	select {
+	case err := <-called:
+		req.finish(err)
+		// the response header carries trailers instead of the request metadata
+		req.h.Metadata = info.Trailer()
 		if err != nil {
-			req.h.Error = err.Error()
+			setHeaderError(req.h, err)
 			s.sendResponse(cc, req.h, invalidRequest, sending)
-			close(sent)
 			return
 		}
 		s.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-		close(sent)
-	}()
+	case <-ctx.Done():
+		// the request is cancelled by client or the connection is closed, no one is waiting for the reply
+		req.finish(Errorf(ContextCode(ctx.Err()), "rpc server: %w", ctx.Err()))
+		if ctx.Err() == context.DeadlineExceeded {
+			req.h.Metadata = nil
+			setHeaderError(req.h, Errorf(CodeDeadlineExceeded, "rpc server: request handle timeout: expect within %s", timeout))
+			s.sendResponse(cc, req.h, invalidRequest, sending)
+		}
+	}
+}
 
-	if timeout == 0 {
-		<-called
-		<-sent
+// replyError replies err to a request which isn't handled
+func (s *Server) replyError(sc *serverConn, req *request, err error) {
+	s.beginMetrics(req)
+	req.finish(err)
+	if req.h.Kind == codec.KindNotify {
+		// no one is waiting for the error
+		if req.mtype != nil {
+			req.mtype.notifyFailed()
+		} else {
+			atomic.AddUint64(&s.numNotifyErrors, 1)
+		}
+		s.log().Warn("rpc server: notify error", "remote", sc.remoteAddr, "seq", req.h.Seq, "method", req.h.ServiceMethod, "err", err)
 		return
 	}
+	h := &codec.Header{ServiceMethod: req.h.ServiceMethod, Seq: req.h.Seq, Kind: req.h.Kind}
+	if h.Kind == codec.KindStream {
+		h.Kind = codec.KindStreamEnd
+	}
+	setHeaderError(h, err)
+	s.sendResponse(sc.cc, h, invalidRequest, &sc.sending)
+}
 
+// handleNotify waits for a one-way call, which is never replied, its error is logged instead
+func (s *Server) handleNotify(ctx context.Context, req *request, called <-chan error) {
+	var err error
 	select {
-	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		s.sendResponse(cc, req.h, invalidRequest, sending)
-	case <-called:
-		<-sent
+	case err = <-called:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			err = Errorf(CodeDeadlineExceeded, "rpc server: request handle timeout: %w", ctx.Err())
+		} else {
+			// the connection is closed, it's no error of the method
+			req.finish(Errorf(CodeCanceled, "rpc server: %w", ctx.Err()))
+			return
+		}
+	}
+	req.finish(err)
+	if err != nil {
+		req.mtype.notifyFailed()
+		s.log().Warn("rpc server: notify error", "remote", req.info.RemoteAddr, "seq", req.h.Seq, "method", req.h.ServiceMethod, "err", err)
 	}
 }
 
@@ -246,6 +632,6 @@ func (s *Server) sendResponse(cc codec.Codec, h *codec.Header, body any, sending
 	defer sending.Unlock()
 
 	if err := cc.Write(h, body); err != nil {
-		fmt.Println("rpc server: write response error: ", err)
+		s.log().Error("rpc server: write response error", "seq", h.Seq, "method", h.ServiceMethod, "err", err)
 	}
 }
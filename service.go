@@ -1,12 +1,21 @@
 package nami
 
 import (
-	"fmt"
+	"context"
 	"go/ast"
 	"reflect"
+	runtimedebug "runtime/debug"
 	"sync/atomic"
 )
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+	typeOfServerStream  = reflect.TypeOf((*ServerStream)(nil)).Elem()
+	typeOfReceiveStream = reflect.TypeOf((*ReceiveStream)(nil)).Elem()
+)
+
 type service struct {
 	name   string
 	typ    reflect.Type           // struct's type
@@ -19,9 +28,6 @@ func newService(rcvr any) *service {
 	s.rcvr = reflect.ValueOf(rcvr)
 	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		fmt.Printf("rpc service: %s is not a valid service name", s.name)
-	}
 	s.registMethods()
 	return s
 }
@@ -33,40 +39,81 @@ func (s *service) registMethods() {
 		method := s.typ.Method(i)
 		mType := method.Type
 
-		// skip if argv's count not mathing
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// skip if argv's count not mathing, ctx is optional
+		if (mType.NumIn() != 3 && mType.NumIn() != 4) || mType.NumOut() != 1 {
 			continue
 		}
 
 		// skip if method not return error
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		if mType.Out(0) != typeOfError {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		hasContext := mType.NumIn() == 4
+		if hasContext && mType.In(1) != typeOfContext {
+			continue
+		}
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
 
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+		mtype := &methodType{
+			method:     method,
+			ArgType:    argType,
+			ReplyType:  replyType,
+			hasContext: hasContext,
 		}
-
-		fmt.Printf("rpc server: regist %s.%s \n", s.name, method.Name)
+		elem, sends := isServerStream(replyType)
+		if elem != nil && !isExportedOrBuiltinType(elem) {
+			continue
+		}
+		mtype.streamElem = elem
+		switch {
+		case argType == typeOfReceiveStream && sends:
+			mtype.streamKind = bidiStreamMethod
+		case argType == typeOfReceiveStream:
+			mtype.streamKind = clientStreamMethod
+		case sends:
+			mtype.streamKind = serverStreamMethod
+		}
+		s.method[method.Name] = mtype
 	}
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+// call calls the method m, a panic of the method is recovered and returned as an internal error
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&m.numPanics, 1)
+			p := &panicError{value: r, stack: runtimedebug.Stack()}
+			err = Errorf(CodeInternal, "rpc server: %s.%s: %w", s.name, m.method.Name, p)
+		}
+	}()
+
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.hasContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
 	return nil
 }
 
+// panicError is the cause of the error returned for a panic, its value and stack are
+// only logged by the server, the client gets a generic message
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return "internal error"
+}
+
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
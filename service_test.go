@@ -1,9 +1,14 @@
 package nami
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/xeasy/nami/codec"
 )
 
 type Foo int
@@ -20,6 +25,43 @@ func (f Foo) sum(args Args, reply *int) error {
 	return nil
 }
 
+type Bar int
+
+func (b Bar) Info(ctx context.Context, args Args, reply *string) error {
+	info, ok := RequestInfoFromContext(ctx)
+	if !ok {
+		return errors.New("no request info")
+	}
+	*reply = info.ServiceMethod
+	return nil
+}
+
+func (b Bar) Bad(ctx string, args Args, reply *string) error {
+	return nil
+}
+
+type Baz int
+
+func (b Baz) Panic(args Args, reply *int) error {
+	panic("baz: " + fmt.Sprint(args.Num1))
+}
+
+type Qux int
+
+// quxStream embeds a TypedServerStream, but isn't one
+type quxStream struct {
+	count int
+	TypedServerStream[int]
+}
+
+func (q Qux) Count(args Args, stream quxStream) error {
+	return stream.Send(args.Num1)
+}
+
+func (q Qux) Squares(args Args, stream TypedServerStream[int]) error {
+	return stream.Send(args.Num1 * args.Num1)
+}
+
 func _assert(condition bool, msg string, v ...any) {
 	if !condition {
 		panic(fmt.Sprintf("assertion failed: "+msg, v...))
@@ -34,6 +76,14 @@ func TestNewService(t *testing.T) {
 	_assert(mType != nil, "wrong Method, Sum shoudn't nil")
 }
 
+func TestServiceTypedStream(t *testing.T) {
+	var qux Qux
+	s := newService(&qux)
+	_assert(s.method["Count"] == nil, "expect a struct embedding TypedServerStream not to be a stream")
+	mType := s.method["Squares"]
+	_assert(mType != nil && mType.streamElem == reflect.TypeOf(0), "expect Squares to stream ints, got %v", mType)
+}
+
 func TestMethodTypeCall(t *testing.T) {
 	var foo Foo
 	s := newService(&foo)
@@ -42,6 +92,40 @@ func TestMethodTypeCall(t *testing.T) {
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "fail to call Foo.Sum")
 }
+
+func TestServiceContextMethod(t *testing.T) {
+	var bar Bar
+	s := newService(&bar)
+	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
+	mType := s.method["Info"]
+	_assert(mType != nil && mType.HasContext(), "wrong Method, Info should take a context")
+
+	ctx := withRequestInfo(context.Background(), &RequestInfo{ServiceMethod: "Bar.Info"})
+	replyv := mType.newReplyv()
+	err := s.call(ctx, mType, mType.newArgv(), replyv)
+	_assert(err == nil && *replyv.Interface().(*string) == "Bar.Info", "fail to call Bar.Info: %v", err)
+}
+
+func TestServicePanic(t *testing.T) {
+	var baz Baz
+	s := NewServer()
+	_ = s.Regiest(&baz)
+	req := &request{h: &codec.Header{ServiceMethod: "Baz.Panic"}}
+	req.svc, req.mtype, _ = s.findService("Baz.Panic")
+	req.argv, req.replyv = reflect.ValueOf(Args{Num1: 1}), req.mtype.newReplyv()
+
+	err := s.invoke(context.Background(), req)
+	_assert(errors.Is(err, ErrInternal) && !strings.Contains(err.Error(), "baz: 1"), "expect panic to become a generic internal error, got %v", err)
+	_assert(req.mtype.NumPanics() == 1 && req.mtype.NumCalls() == 1, "expect the panic to be counted, got %d", req.mtype.NumPanics())
+
+	s.SetRepanic(true)
+	defer func() {
+		r := recover()
+		_assert(r == "baz: 1", "expect the panic to be repanicked, got %v", r)
+	}()
+	_ = s.invoke(context.Background(), req)
+	t.Fatal("invoke should repanic")
+}
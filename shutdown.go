@@ -0,0 +1,136 @@
+package nami
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xeasy/nami/codec"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether connections are idle
+const shutdownPollInterval = time.Millisecond * 50
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+// trackListener adds or removes lis, adding fails when server is shutting down
+func (s *Server) trackListener(lis net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, lis)
+		return true
+	}
+	if s.inShutdown {
+		return false
+	}
+	s.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn adds or removes sc, adding fails when server is shutting down
+func (s *Server) trackConn(sc *serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, sc)
+		return true
+	}
+	if s.inShutdown {
+		return false
+	}
+	s.conns[sc] = struct{}{}
+	return true
+}
+
+// Shutdown gracefully shuts down the server: it closes all listeners, tells connected
+// clients to stop sending requests, then waits for in-flight requests to be handled and
+// closes the connections. Remaining connections are closed when ctx is done, and the
+// ctx error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	s.closeListenersLocked()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mu.Unlock()
+
+	// a peer not reading blocks its write until the connection is closed, by Close once ctx is done
+	var wg sync.WaitGroup
+	for _, sc := range conns {
+		wg.Add(1)
+		go func(sc *serverConn) {
+			defer wg.Done()
+			s.sendResponse(sc.cc, &codec.Header{Kind: codec.KindGoAway}, invalidRequest, &sc.sending)
+		}(sc)
+	}
+	sent := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections, in-flight requests are dropped
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inShutdown = true
+	s.closeListenersLocked()
+	for sc := range s.conns {
+		sc.close()
+		delete(s.conns, sc)
+	}
+	return nil
+}
+
+func (s *Server) closeListenersLocked() {
+	for lis := range s.listeners {
+		lis.Close()
+		delete(s.listeners, lis)
+	}
+}
+
+// closeIdleConns closes connections without request in flight, and reports whether all are closed
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sc := range s.conns {
+		if sc.closeIfIdle() {
+			delete(s.conns, sc)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// Shutdown gracefully shuts down the DefaultServer
+func Shutdown(ctx context.Context) error {
+	return DefaultServer.Shutdown(ctx)
+}
@@ -0,0 +1,305 @@
+package nami
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/xeasy/nami/codec"
+)
+
+// ServerStream sends the replies of a server-streaming method, which is declared as
+//
+//	func (t *T) MethodName(args T1, stream nami.ServerStream) error
+//
+// optionally with a context.Context as first argument. Each Send becomes a message of
+// the stream, the stream ends when the method returns.
+type ServerStream interface {
+	// Context returns the context of the request, it's done when the client gives up the stream
+	Context() context.Context
+	// Send sends msg to client, it blocks while the client has no room for more messages
+	Send(msg any) error
+}
+
+// TypedServerStream is a ServerStream sending messages of type R only, it's declared as
+//
+//	func (t *T) MethodName(args T1, stream nami.TypedServerStream[R]) error
+//
+// in place of ServerStream. Messages sent through the embedded ServerStream are checked to be R.
+type TypedServerStream[R any] struct {
+	ServerStream
+}
+
+// Send sends msg to client, it blocks while the client has no room for more messages
+func (s TypedServerStream[R]) Send(msg R) error {
+	return s.ServerStream.Send(msg)
+}
+
+func (TypedServerStream[R]) streamElem() reflect.Type {
+	return reflect.TypeOf((*R)(nil)).Elem()
+}
+
+// typedServerStream is implemented by every TypedServerStream
+type typedServerStream interface {
+	streamElem() reflect.Type
+}
+
+var typeOfTypedServerStream = reflect.TypeOf((*typedServerStream)(nil)).Elem()
+
+// isServerStream reports whether t is ServerStream or a TypedServerStream, and returns the type of
+// its messages, nil if it's untyped. Types embedding a TypedServerStream don't count, as the stream
+// is set into the only field of a TypedServerStream.
+func isServerStream(t reflect.Type) (elem reflect.Type, ok bool) {
+	if t == typeOfServerStream {
+		return nil, true
+	}
+	if t.Kind() == reflect.Struct && t.PkgPath() == typeOfServerStream.PkgPath() &&
+		strings.HasPrefix(t.Name(), "TypedServerStream[") && t.Implements(typeOfTypedServerStream) {
+		return reflect.Zero(t).Interface().(typedServerStream).streamElem(), true
+	}
+	return nil, false
+}
+
+// ReceiveStream receives the messages sent by client to a client-streaming method
+//
+//	func (t *T) MethodName(stream nami.ReceiveStream, reply *T2) error
+//
+// or to a bidirectional streaming method, which sends messages back on a ServerStream
+//
+//	func (t *T) MethodName(in nami.ReceiveStream, out nami.ServerStream) error
+//
+// both optionally with a context.Context as first argument. The reply of a client-streaming
+// method is sent as the only message of the stream once the method returns.
+type ReceiveStream interface {
+	Context() context.Context
+	// Recv receives the next message into msg, it returns io.EOF once the client closed its side
+	Recv(msg any) error
+}
+
+// serverStream is the ServerStream and ReceiveStream of a request. Each side sends
+// messages as long as the other side granted credits, so a slow receiver can't pile up
+// messages on the sender, nor the other way around.
+type serverStream struct {
+	ctx       context.Context
+	sc        *serverConn
+	seq       uint64
+	window    int
+	elem      reflect.Type // of the messages sent by a TypedServerStream, nil if they're untyped
+	marshaler codec.BodyMarshaler
+	consumed  int // messages received since the last ack
+
+	mu       sync.Mutex // protect following
+	credits  int
+	acked    chan struct{} // notified when credits are granted
+	in       chan []byte   // marshaled messages from client
+	inErr    error         // set before in is closed
+	inClosed bool
+}
+
+var (
+	_ ServerStream  = (*serverStream)(nil)
+	_ ReceiveStream = (*serverStream)(nil)
+)
+
+func newServerStream(ctx context.Context, sc *serverConn, seq uint64) *serverStream {
+	window := sc.opt.StreamWindowSize()
+	return &serverStream{
+		ctx:       ctx,
+		sc:        sc,
+		seq:       seq,
+		window:    window,
+		marshaler: codec.MarshalerOf(sc.cc),
+		credits:   window,
+		acked:     make(chan struct{}, 1),
+		in:        make(chan []byte, window),
+	}
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *serverStream) Send(msg any) error {
+	if ss.elem != nil && !isElem(msg, ss.elem) {
+		return Errorf(CodeInternal, "rpc server: stream of %s can't send %T", ss.elem, msg)
+	}
+	for {
+		ss.mu.Lock()
+		if ss.credits > 0 {
+			ss.credits--
+			ss.mu.Unlock()
+			break
+		}
+		ss.mu.Unlock()
+
+		select {
+		case <-ss.acked:
+		case <-ss.ctx.Done():
+			return ss.ctx.Err()
+		}
+	}
+	return ss.sc.write(&codec.Header{Kind: codec.KindStreamMsg, Seq: ss.seq}, msg)
+}
+
+// isElem reports whether msg is of type elem, or implements it if it's an interface
+func isElem(msg any, elem reflect.Type) bool {
+	t := reflect.TypeOf(msg)
+	if elem.Kind() == reflect.Interface {
+		return t == nil || t.Implements(elem)
+	}
+	return t == elem
+}
+
+// grant gives the stream n more credits
+func (ss *serverStream) grant(n int) {
+	ss.mu.Lock()
+	ss.credits += n
+	ss.mu.Unlock()
+	select {
+	case ss.acked <- struct{}{}:
+	default:
+	}
+}
+
+func (ss *serverStream) Recv(msg any) error {
+	select {
+	case data, ok := <-ss.in:
+		if !ok {
+			return ss.inErr
+		}
+		if err := ss.marshaler.UnmarshalBody(data, msg); err != nil {
+			return err
+		}
+		// grant client credits for the consumed messages, half a window at a time
+		if ss.consumed++; ss.consumed >= (ss.window+1)/2 {
+			if err := ss.sc.write(&codec.Header{Kind: codec.KindStreamAck, Seq: ss.seq}, ss.consumed); err != nil {
+				return err
+			}
+			ss.consumed = 0
+		}
+		return nil
+	case <-ss.ctx.Done():
+		return ss.ctx.Err()
+	}
+}
+
+// push queues a message sent by client
+func (ss *serverStream) push(data []byte) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.inClosed {
+		return
+	}
+	select {
+	case ss.in <- data:
+	default:
+		// client sent more messages than granted
+		ss.closeInLocked(NewError(CodeResourceExhausted, "rpc server: stream window overflow"))
+	}
+}
+
+// closeIn ends the messages from client with err, io.EOF if it's nil
+func (ss *serverStream) closeIn(err error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.closeInLocked(err)
+}
+
+func (ss *serverStream) closeInLocked(err error) {
+	if ss.inClosed {
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	ss.inErr = err
+	ss.inClosed = true
+	close(ss.in)
+}
+
+// handleControl handles a control frame sent by client, whose body is still to be read
+func (s *Server) handleControl(sc *serverConn, h *codec.Header) error {
+	switch h.Kind {
+	case codec.KindCancel:
+		if err := sc.cc.ReadBody(nil); err != nil {
+			return err
+		}
+		sc.cancelRequest(h.Seq)
+	case codec.KindStreamAck:
+		var credits int
+		if err := sc.cc.ReadBody(&credits); err != nil {
+			return err
+		}
+		if ss := sc.stream(h.Seq); ss != nil {
+			ss.grant(credits)
+		}
+	case codec.KindStreamMsg:
+		var data []byte
+		if err := sc.cc.ReadBody(&data); err != nil {
+			return err
+		}
+		if ss := sc.stream(h.Seq); ss != nil {
+			ss.push(data)
+		}
+	case codec.KindStreamEnd:
+		if err := sc.cc.ReadBody(nil); err != nil {
+			return err
+		}
+		if ss := sc.stream(h.Seq); ss != nil {
+			ss.closeIn(nil)
+		}
+	default:
+		return fmt.Errorf("rpc server: unexpected control frame kind %d", h.Kind)
+	}
+	return nil
+}
+
+func isControl(kind codec.Kind) bool {
+	switch kind {
+	case codec.KindCancel, codec.KindStreamAck, codec.KindStreamMsg, codec.KindStreamEnd:
+		return true
+	}
+	return false
+}
+
+// handleStream calls a streaming method, the stream ends when the method returns
+func (s *Server) handleStream(ctx context.Context, sc *serverConn, req *request, info *RequestInfo) {
+	ss := req.stream
+	ss.ctx = ctx // carries the RequestInfo now
+	defer sc.trackStream(ss, false)
+
+	if req.mtype.receivesStream() {
+		req.argv = reflect.ValueOf(ss)
+	}
+	if req.mtype.sendsStream() {
+		req.replyv = reflect.ValueOf(ss)
+		if req.mtype.ReplyType != typeOfServerStream {
+			// a TypedServerStream embedding ss
+			ss.elem = req.mtype.streamElem
+			req.replyv = reflect.New(req.mtype.ReplyType).Elem()
+			req.replyv.Field(0).Set(reflect.ValueOf(ServerStream(ss)))
+		}
+	}
+	err := s.invoke(ctx, req)
+	if err == nil && req.mtype.streamKind == clientStreamMethod {
+		err = ss.Send(req.replyv.Interface())
+	}
+	if ctx.Err() == context.Canceled {
+		// cancelled by client or the connection is closed, no one is waiting for the end
+		req.finish(Errorf(CodeCanceled, "rpc server: %w", ctx.Err()))
+		return
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = Errorf(CodeDeadlineExceeded, "rpc server: stream handle timeout: %w", ctx.Err())
+	}
+	req.finish(err)
+
+	h := &codec.Header{Kind: codec.KindStreamEnd, Seq: req.h.Seq, Metadata: info.Trailer()}
+	if err != nil {
+		setHeaderError(h, err)
+	}
+	s.sendResponse(sc.cc, h, invalidRequest, &sc.sending)
+}
@@ -1,10 +1,11 @@
 package xclient
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/xeasy/nami"
 )
 
 type RegistryDiscovery struct {
@@ -12,6 +13,21 @@ type RegistryDiscovery struct {
 	registry      string
 	timeout       time.Duration
 	lastUpdatedAt time.Time
+	logger        nami.Logger
+}
+
+// SetLogger makes r log with l, nil restores nami.DefaultLogger()
+func (r *RegistryDiscovery) SetLogger(l nami.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = l
+}
+
+func (r *RegistryDiscovery) logLocked() nami.Logger {
+	if r.logger != nil {
+		return r.logger
+	}
+	return nami.DefaultLogger()
 }
 
 func (r *RegistryDiscovery) Refresh() error {
@@ -20,10 +36,10 @@ func (r *RegistryDiscovery) Refresh() error {
 	if r.lastUpdatedAt.Add(r.timeout).After(time.Now()) {
 		return nil
 	}
-	fmt.Println("rpc server: refresh servers from registry ", r.registry)
+	r.logLocked().Debug("rpc xclient: refresh servers", "registry", r.registry)
 	resp, err := http.Get(r.registry)
 	if err != nil {
-		fmt.Println("rpc server: refresh servers fail ", err)
+		r.logLocked().Warn("rpc xclient: refresh servers error", "registry", r.registry, "err", err)
 		return err
 	}
 	servers := strings.Split(resp.Header.Get("X-Namirpc-Servers"), ",")
@@ -2,6 +2,7 @@ package xclient
 
 import (
 	"context"
+	"errors"
 	"io"
 	"reflect"
 	"sync"
@@ -16,6 +17,8 @@ type XClient struct {
 	opt     *nami.Option
 	mu      sync.Mutex
 	clients map[string]client.NClient // client cache
+
+	interceptors []client.Interceptor
 }
 
 // make sure XClient represented io.Closer interface
@@ -72,12 +75,53 @@ func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod strin
 	return cli.Call(ctx, serviceMethod, args, reply)
 }
 
-func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply any) error {
+// Use appends interceptors wrapping Call, the first one is the outermost.
+// They are invoked once per Call, whichever server is selected.
+func (xc *XClient) Use(interceptors ...client.Interceptor) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.interceptors = append(xc.interceptors[:len(xc.interceptors):len(xc.interceptors)], interceptors...)
+}
+
+func (xc *XClient) invoke(ctx context.Context, call *client.Call) error {
 	rpcAddr, err := xc.d.Get(xc.mode)
 	if err != nil {
 		return err
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	err = xc.call(rpcAddr, ctx, call.ServiceMethod, call.Args, call.Reply)
+	if !errors.Is(err, nami.ErrOverloaded) {
+		return err
+	}
+
+	// the server shed the call without handling it, fail over to the other servers
+	xc.opt.Log().Debug("rpc xclient: server overloaded, failing over", "remote", rpcAddr, "method", call.ServiceMethod)
+	servers, e := xc.d.GetAll()
+	if e != nil {
+		return err
+	}
+	for _, addr := range servers {
+		if addr == rpcAddr {
+			continue
+		}
+		if err = xc.call(addr, ctx, call.ServiceMethod, call.Args, call.Reply); !errors.Is(err, nami.ErrOverloaded) {
+			return err
+		}
+	}
+	return err
+}
+
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply any) error {
+	xc.mu.Lock()
+	interceptors := xc.interceptors
+	xc.mu.Unlock()
+
+	call := &client.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+	}
+	call.Error = client.ChainInterceptors(interceptors, xc.invoke)(ctx, call)
+	return call.Error
 }
 
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply any) error {
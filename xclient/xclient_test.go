@@ -0,0 +1,86 @@
+package xclient
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xeasy/nami"
+	"github.com/xeasy/nami/client"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(args.Num1))
+	return nil
+}
+
+// holds gets a channel from Foo.Hold once it's called, closing it makes Foo.Hold return
+var holds = make(chan chan struct{})
+
+func (f Foo) Hold(args Args, reply *int) error {
+	release := make(chan struct{})
+	holds <- release
+	<-release
+	return nil
+}
+
+func startServer(t *testing.T, limits nami.Limits) string {
+	var foo Foo
+	server := nami.NewServer()
+	_ = server.Regiest(&foo)
+	server.SetLimits(limits)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error: ", err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { server.Close() })
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClientFailover(t *testing.T) {
+	busy := startServer(t, nami.Limits{MaxInFlight: 1})
+	idle := startServer(t, nami.Limits{})
+
+	// keep the busy server at its limit
+	cli, err := client.XDial(busy)
+	if err != nil {
+		t.Fatal("dial failed: ", err)
+	}
+	defer cli.Close()
+	holding := cli.Go("Foo.Hold", &Args{}, new(int), nil)
+	release := <-holds
+
+	xc := NewXClient(NewMultiServersDiscovery([]string{busy, idle}), RoundRobinSelect, nil)
+	defer xc.Close()
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect the call to fail over to the idle server, got %d, err %v", reply, err)
+	}
+	close(release)
+	<-holding.Done
+}
+
+func TestRegistryDiscoveryLogger(t *testing.T) {
+	var buf bytes.Buffer
+	d := NewRegistryDiscovery("http://127.0.0.1:0/_namirpc_/regiest", 0)
+	d.SetLogger(nami.NewTextLogger(&buf, nami.LevelDebug))
+	if err := d.Refresh(); err == nil {
+		t.Fatal("expect refresh from an unreachable registry to fail")
+	}
+	if !strings.Contains(buf.String(), "WARN rpc xclient: refresh servers error") {
+		t.Fatalf("expect the error to be logged by the discovery's logger: %q", buf.String())
+	}
+}